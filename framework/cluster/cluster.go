@@ -0,0 +1,382 @@
+package cluster
+
+import (
+	"errors"
+	"g_server/framework/com"
+	"g_server/framework/discovery"
+	"g_server/framework/log"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"g_server/framework/session"
+	"time"
+)
+
+//NodeInfo 节点配置
+type NodeInfo struct {
+	Id   uint32
+	Role string
+	Host string //监听地址
+	Url  string //其他节点连过来的地址
+}
+
+type clusterService struct {
+	handler func(uint32, protocolbase.IMsg) (protocolbase.IMsg, error)
+	create  func() protocolbase.IMsg
+}
+
+type clusterCall struct {
+	nodeid   uint32
+	resp     protocolbase.IMsg
+	callback func(protocolbase.IMsg, error)
+	deadline time.Time
+}
+
+//Cluster 节点之间的调用,每个节点主动连所有其他节点,请求走主动连的连接,返回从原连接回来
+type Cluster struct {
+	self       *NodeInfo
+	nodes      map[uint32]*NodeInfo
+	server     *session.SessionManager
+	links      map[uint32]*session.SessionClient
+	peers      map[uint64]uint32
+	services   map[string]*clusterService
+	calls      map[uint64]*clusterCall
+	callid     uint64
+	timeout    time.Duration
+	maxmsgsize uint32
+	maxpeers   uint32
+	disc       discovery.IDiscovery
+	fnodeopen  []func(uint32)
+	fnodelost  []func(uint32)
+	name       string
+}
+
+//Self 自己的节点
+func (cluster *Cluster) Self() *NodeInfo {
+	return cluster.self
+}
+
+//GetNode 取节点配置
+func (cluster *Cluster) GetNode(nodeid uint32) *NodeInfo {
+	return cluster.nodes[nodeid]
+}
+
+//NodesByRole 某个角色的所有节点
+func (cluster *Cluster) NodesByRole(role string) []*NodeInfo {
+	nodes := make([]*NodeInfo, 0)
+	for _, node := range cluster.nodes {
+		if node.Role == role {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+//UseDiscovery 用服务发现代替静态配置,Start时注册自己,节点出现和消失时自动连接和断开
+func (cluster *Cluster) UseDiscovery(disc discovery.IDiscovery) {
+	cluster.disc = disc
+}
+
+func (cluster *Cluster) onDiscovery(event int, record *discovery.NodeRecord) {
+	if record.Id == cluster.self.Id {
+		return
+	}
+	switch event {
+	case discovery.NodeAdd:
+		node := &NodeInfo{Id: record.Id, Role: record.Role, Host: record.Host, Url: record.Url}
+		if old, ok := cluster.nodes[node.Id]; ok && *old == *node {
+			if _, ok := cluster.links[node.Id]; ok {
+				return
+			}
+		}
+		cluster.removeLink(node.Id)
+		cluster.nodes[node.Id] = node
+		cluster.addLink(node)
+	case discovery.NodeRemove:
+		cluster.removeLink(record.Id)
+		delete(cluster.nodes, record.Id)
+	}
+}
+
+//RegNodeOpen 连上其他节点时调用
+func (cluster *Cluster) RegNodeOpen(f func(uint32)) {
+	cluster.fnodeopen = append(cluster.fnodeopen, f)
+}
+
+//RegNodeLost 其他节点连过来的连接断开时调用
+func (cluster *Cluster) RegNodeLost(f func(uint32)) {
+	cluster.fnodelost = append(cluster.fnodelost, f)
+}
+
+func (cluster *Cluster) notifyNode(fs []func(uint32), nodeid uint32) {
+	for _, f := range fs {
+		com.SafeCall(func() {
+			f(nodeid)
+		})
+	}
+}
+
+//SetCallTimeout 设置调用超时
+func (cluster *Cluster) SetCallTimeout(timeout time.Duration) {
+	cluster.timeout = timeout
+}
+
+//SetMaxPeers 设置最多接受多少个其他节点连进来,用服务发现时节点数启动时还不知道,在Start之前调用
+func (cluster *Cluster) SetMaxPeers(maxpeers uint32) {
+	cluster.maxpeers = maxpeers
+}
+
+//RegService 注册服务,handler的第一个参数是调用方节点ID,返回nil消息表示没有返回内容
+func (cluster *Cluster) RegService(name string, handler func(uint32, protocolbase.IMsg) (protocolbase.IMsg, error), create func() protocolbase.IMsg) {
+	cluster.services[name] = &clusterService{handler: handler, create: create}
+}
+
+//Call 调用其他节点的服务,callback在主循环调用,resp为用来解返回数据的消息
+func (cluster *Cluster) Call(nodeid uint32, service string, req protocolbase.IMsg, resp protocolbase.IMsg, callback func(protocolbase.IMsg, error)) {
+	link, err := cluster.validLink(nodeid)
+	if err != nil {
+		if callback != nil {
+			com.SafeCall(func() {
+				callback(resp, err)
+			})
+		}
+		return
+	}
+	cluster.callid++
+	cluster.calls[cluster.callid] = &clusterCall{nodeid: nodeid, resp: resp, callback: callback, deadline: time.Now().Add(cluster.timeout)}
+	link.SendMsg(&clusterMsgRequest{callid: cluster.callid, service: service, body: packBody(req)})
+}
+
+//Notify 调用其他节点的服务,不需要返回
+func (cluster *Cluster) Notify(nodeid uint32, service string, req protocolbase.IMsg) error {
+	link, err := cluster.validLink(nodeid)
+	if err != nil {
+		return err
+	}
+	link.SendMsg(&clusterMsgRequest{service: service, body: packBody(req)})
+	return nil
+}
+
+//Broadcast 通知某个角色的所有节点,返回发送成功的个数
+func (cluster *Cluster) Broadcast(role string, service string, req protocolbase.IMsg) int {
+	body := packBody(req)
+	count := 0
+	for _, node := range cluster.NodesByRole(role) {
+		if link, err := cluster.validLink(node.Id); err == nil {
+			link.SendMsg(&clusterMsgRequest{service: service, body: body})
+			count++
+		}
+	}
+	return count
+}
+
+func (cluster *Cluster) validLink(nodeid uint32) (*session.SessionClient, error) {
+	link, ok := cluster.links[nodeid]
+	if !ok {
+		return nil, ErrNodeNotFound
+	}
+	if !link.Valid() {
+		return nil, ErrNodeInvalid
+	}
+	return link, nil
+}
+
+func packBody(msg protocolbase.IMsg) []byte {
+	packer := msgpack.PopPacker()
+	defer msgpack.PushPacker(packer)
+	packer.ClearBuffer()
+	msg.Pack(packer, true)
+	data := packer.GetBuffer()
+	body := make([]byte, len(data))
+	copy(body, data)
+	return body
+}
+
+func unpackBody(msg protocolbase.IMsg, body []byte) bool {
+	unpacker := msgpack.PopUnPacker()
+	defer msgpack.PushUnPacker(unpacker)
+	unpacker.Attatch(body)
+	//和handleMsg一样先读协议号,生成的协议打包时都带着
+	if r, id := unpacker.UnPackUInt32(); r != 0 || id != msg.GetProId() {
+		return false
+	}
+	return msg.Unpack(unpacker) == 0
+}
+
+//addLink 连接其他节点,断线后SessionClient会自动重连
+func (cluster *Cluster) addLink(node *NodeInfo) {
+	if node.Id == cluster.self.Id {
+		return
+	}
+	if _, ok := cluster.links[node.Id]; ok {
+		return
+	}
+	nodeid := node.Id
+	link := session.NewWsSessionClient(cluster.name+"."+node.Role, node.Url, clusterRconTime, cluster.maxmsgsize)
+	link.RegIMsgHandler(ClusterMsgIdResponse, func(s session.ISession, msg protocolbase.IMsg, ok bool) {
+		if ok {
+			cluster.onResponse(msg.(*clusterMsgResponse))
+		}
+	}, func() protocolbase.IMsg { return &clusterMsgResponse{} })
+	link.RegSessionOpen(func(s session.ISession) {
+		s.SendMsg(&clusterMsgHello{nodeid: cluster.self.Id})
+		cluster.notifyNode(cluster.fnodeopen, nodeid)
+	})
+	link.RegSessionClose(func(s session.ISession) {
+		cluster.failCalls(nodeid, ErrNodeLost)
+	})
+	cluster.links[nodeid] = link
+	link.Start()
+}
+
+//removeLink 断开其他节点
+func (cluster *Cluster) removeLink(nodeid uint32) {
+	if link, ok := cluster.links[nodeid]; ok {
+		delete(cluster.links, nodeid)
+		link.Stop()
+		cluster.failCalls(nodeid, ErrNodeLost)
+	}
+}
+
+func (cluster *Cluster) onRequest(s session.ISession, msg *clusterMsgRequest) {
+	from, ok := cluster.peers[s.ID()]
+	if !ok {
+		glog.LogConsole(glog.LogWarning, "cluster request before hello", s.IpStr(), msg.service)
+		return
+	}
+	var (
+		resp protocolbase.IMsg
+		err  error
+	)
+	if service, ok := cluster.services[msg.service]; !ok {
+		err = ErrServiceNotFound
+	} else if req := service.create(); req == nil || !unpackBody(req, msg.body) {
+		err = ErrRequestUnpack
+	} else {
+		//处理函数异常了也要返回
+		err = ErrServicePanic
+		com.SafeCall(func() {
+			resp, err = service.handler(from, req)
+		})
+	}
+	if msg.callid == 0 {
+		if err != nil {
+			glog.LogConsole(glog.LogWarning, "cluster notify fail", from, msg.service, err)
+		}
+		return
+	}
+	result := &clusterMsgResponse{callid: msg.callid}
+	if err != nil {
+		result.errstr = err.Error()
+	} else if resp != nil {
+		result.body = packBody(resp)
+	}
+	s.SendMsg(result)
+}
+
+func (cluster *Cluster) onResponse(msg *clusterMsgResponse) {
+	call, ok := cluster.calls[msg.callid]
+	if !ok {
+		return
+	}
+	delete(cluster.calls, msg.callid)
+	var err error
+	if msg.errstr != "" {
+		err = errors.New(msg.errstr)
+	} else if call.resp != nil && len(msg.body) > 0 && !unpackBody(call.resp, msg.body) {
+		err = ErrResponseUnpack
+	}
+	cluster.doCallback(call, err)
+}
+
+func (cluster *Cluster) doCallback(call *clusterCall, err error) {
+	if call.callback != nil {
+		com.SafeCall(func() {
+			call.callback(call.resp, err)
+		})
+	}
+}
+
+func (cluster *Cluster) failCalls(nodeid uint32, err error) {
+	for id, call := range cluster.calls {
+		if call.nodeid == nodeid {
+			delete(cluster.calls, id)
+			cluster.doCallback(call, err)
+		}
+	}
+}
+
+func (cluster *Cluster) checkTimeout() {
+	now := time.Now()
+	for id, call := range cluster.calls {
+		if now.After(call.deadline) {
+			delete(cluster.calls, id)
+			cluster.doCallback(call, ErrCallTimeout)
+		}
+	}
+}
+
+func (cluster *Cluster) Run() {
+	cluster.server.Run()
+	for _, link := range cluster.links {
+		link.Run()
+	}
+	cluster.checkTimeout()
+}
+
+func (cluster *Cluster) Start() bool {
+	if cluster.self == nil {
+		glog.LogConsole(glog.LogError, "cluster self node not found", cluster.name)
+		return false
+	}
+	cluster.server = session.NewWsSessionManager(cluster.name, cluster.self.Host, cluster.maxmsgsize, cluster.maxpeers, nil)
+	cluster.server.RegIMsgHandler(ClusterMsgIdHello, func(s session.ISession, msg protocolbase.IMsg, ok bool) {
+		if ok {
+			cluster.peers[s.ID()] = msg.(*clusterMsgHello).nodeid
+		}
+	}, func() protocolbase.IMsg { return &clusterMsgHello{} })
+	cluster.server.RegIMsgHandler(ClusterMsgIdRequest, func(s session.ISession, msg protocolbase.IMsg, ok bool) {
+		if ok {
+			cluster.onRequest(s, msg.(*clusterMsgRequest))
+		}
+	}, func() protocolbase.IMsg { return &clusterMsgRequest{} })
+	cluster.server.RegSessionClose(func(s session.ISession) {
+		if nodeid, ok := cluster.peers[s.ID()]; ok {
+			delete(cluster.peers, s.ID())
+			cluster.notifyNode(cluster.fnodelost, nodeid)
+		}
+	})
+	if !cluster.server.Start() {
+		return false
+	}
+	for _, node := range cluster.nodes {
+		cluster.addLink(node)
+	}
+	if cluster.disc != nil {
+		self := cluster.self
+		if err := cluster.disc.Register(&discovery.NodeRecord{Id: self.Id, Role: self.Role, Host: self.Host, Url: self.Url}); err != nil {
+			glog.LogConsole(glog.LogError, "cluster register fail", cluster.name, err)
+			//已经开了监听和连接,关掉再返回,没注册上不用Deregister
+			for nodeid := range cluster.links {
+				cluster.removeLink(nodeid)
+			}
+			cluster.server.Stop()
+			return false
+		}
+		cluster.disc.Watch(cluster.onDiscovery)
+	}
+	return true
+}
+
+func (cluster *Cluster) Stop() bool {
+	if cluster.disc != nil {
+		cluster.disc.Deregister(cluster.self.Id)
+	}
+	for nodeid := range cluster.links {
+		cluster.removeLink(nodeid)
+	}
+	return cluster.server.Stop()
+}
+
+func (cluster *Cluster) Name() string {
+	return cluster.name
+}
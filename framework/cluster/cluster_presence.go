@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"g_server/framework/protocolbase"
+)
+
+type presenceKey struct {
+	kind string
+	key  string
+}
+
+//clusterMsgPresence 上线下线和查询都用这个
+type clusterMsgPresence struct {
+	kind string
+	key  string
+}
+
+func (msg *clusterMsgPresence) GetProId() uint32 {
+	return 0
+}
+
+func (msg *clusterMsgPresence) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackString(msg.kind)
+	packer.PackString(msg.key)
+}
+
+func (msg *clusterMsgPresence) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	if r, msg.kind = unpacker.UnPackString(); r != 0 {
+		return unpacker.InField(r, "kind")
+	}
+	r, msg.key = unpacker.UnPackString()
+	return unpacker.InField(r, "key")
+}
+
+//clusterMsgPresenceResult 查询结果,nodeid为0表示不在线
+type clusterMsgPresenceResult struct {
+	nodeid uint32
+}
+
+func (msg *clusterMsgPresenceResult) GetProId() uint32 {
+	return 0
+}
+
+func (msg *clusterMsgPresenceResult) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt32(msg.nodeid)
+}
+
+func (msg *clusterMsgPresenceResult) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	r, msg.nodeid = unpacker.UnPackUInt32()
+	return unpacker.InField(r, "nodeid")
+}
+
+//ClusterPresence 全服在线放在holder节点上,其他节点上线下线通知它,查询走Call
+//实现session.IPresenceStore,给session.Presence.SetStore使用
+type ClusterPresence struct {
+	cluster *Cluster
+	holder  uint32
+	local   map[presenceKey]bool
+	online  map[presenceKey]uint32
+}
+
+func (presence *ClusterPresence) isHolder() bool {
+	return presence.cluster.self.Id == presence.holder
+}
+
+func (presence *ClusterPresence) Online(kind string, key string) {
+	pkey := presenceKey{kind: kind, key: key}
+	presence.local[pkey] = true
+	if presence.isHolder() {
+		presence.online[pkey] = presence.holder
+		return
+	}
+	presence.cluster.Notify(presence.holder, presenceServiceOnline, &clusterMsgPresence{kind: kind, key: key})
+}
+
+func (presence *ClusterPresence) Offline(kind string, key string) {
+	pkey := presenceKey{kind: kind, key: key}
+	delete(presence.local, pkey)
+	if presence.isHolder() {
+		presence.offline(presence.holder, pkey)
+		return
+	}
+	presence.cluster.Notify(presence.holder, presenceServiceOffline, &clusterMsgPresence{kind: kind, key: key})
+}
+
+//Query callback的参数是所在节点
+func (presence *ClusterPresence) Query(kind string, key string, callback func(uint32, bool)) {
+	if presence.isHolder() {
+		nodeid, ok := presence.online[presenceKey{kind: kind, key: key}]
+		callback(nodeid, ok)
+		return
+	}
+	presence.cluster.Call(presence.holder, presenceServiceQuery, &clusterMsgPresence{kind: kind, key: key}, &clusterMsgPresenceResult{}, func(resp protocolbase.IMsg, err error) {
+		if err != nil {
+			callback(0, false)
+			return
+		}
+		nodeid := resp.(*clusterMsgPresenceResult).nodeid
+		callback(nodeid, nodeid != 0)
+	})
+}
+
+//offline 只删自己节点上线的,玩家可能已经在别的节点登录
+func (presence *ClusterPresence) offline(nodeid uint32, pkey presenceKey) {
+	if presence.online[pkey] == nodeid {
+		delete(presence.online, pkey)
+	}
+}
+
+//onNodeOpen 连上holder后把本地在线的重新同步一遍
+func (presence *ClusterPresence) onNodeOpen(nodeid uint32) {
+	if nodeid != presence.holder {
+		return
+	}
+	for pkey := range presence.local {
+		presence.cluster.Notify(presence.holder, presenceServiceOnline, &clusterMsgPresence{kind: pkey.kind, key: pkey.key})
+	}
+}
+
+//onNodeLost 节点断开,上面的玩家都算下线
+func (presence *ClusterPresence) onNodeLost(nodeid uint32) {
+	if !presence.isHolder() {
+		return
+	}
+	for pkey, online := range presence.online {
+		if online == nodeid {
+			delete(presence.online, pkey)
+		}
+	}
+}
+
+func (presence *ClusterPresence) init() {
+	presence.cluster.RegNodeOpen(presence.onNodeOpen)
+	presence.cluster.RegNodeLost(presence.onNodeLost)
+	if !presence.isHolder() {
+		return
+	}
+	presence.cluster.RegService(presenceServiceOnline, func(from uint32, req protocolbase.IMsg) (protocolbase.IMsg, error) {
+		msg := req.(*clusterMsgPresence)
+		presence.online[presenceKey{kind: msg.kind, key: msg.key}] = from
+		return nil, nil
+	}, func() protocolbase.IMsg { return &clusterMsgPresence{} })
+	presence.cluster.RegService(presenceServiceOffline, func(from uint32, req protocolbase.IMsg) (protocolbase.IMsg, error) {
+		msg := req.(*clusterMsgPresence)
+		presence.offline(from, presenceKey{kind: msg.kind, key: msg.key})
+		return nil, nil
+	}, func() protocolbase.IMsg { return &clusterMsgPresence{} })
+	presence.cluster.RegService(presenceServiceQuery, func(from uint32, req protocolbase.IMsg) (protocolbase.IMsg, error) {
+		msg := req.(*clusterMsgPresence)
+		return &clusterMsgPresenceResult{nodeid: presence.online[presenceKey{kind: msg.kind, key: msg.key}]}, nil
+	}, func() protocolbase.IMsg { return &clusterMsgPresence{} })
+}
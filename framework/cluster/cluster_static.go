@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"errors"
+	"g_server/framework/config"
+	"g_server/framework/session"
+	"time"
+)
+
+const (
+	//节点之间的消息号,业务消息不能使用这个段
+	ClusterMsgIdBase     = uint32(0xfffd0000)
+	ClusterMsgIdHello    = ClusterMsgIdBase + 1 //连上后告诉对方自己的节点ID
+	ClusterMsgIdRequest  = ClusterMsgIdBase + 2
+	ClusterMsgIdResponse = ClusterMsgIdBase + 3
+
+	DefaultCallTimeout = 10 * time.Second
+	DefaultMaxPeers    = 1024
+	clusterRconTime    = 3
+
+	presenceServiceOnline  = "cluster.presence.online"
+	presenceServiceOffline = "cluster.presence.offline"
+	presenceServiceQuery   = "cluster.presence.query"
+)
+
+var (
+	ErrNodeNotFound    = errors.New("Err NodeNotFound")
+	ErrNodeInvalid     = errors.New("Err NodeInvalid")
+	ErrNodeLost        = errors.New("Err NodeLost")
+	ErrCallTimeout     = errors.New("Err CallTimeout")
+	ErrServiceNotFound = errors.New("Err ServiceNotFound")
+	ErrServicePanic    = errors.New("Err ServicePanic")
+	ErrRequestUnpack   = errors.New("Err RequestUnpack")
+	ErrResponseUnpack  = errors.New("Err ResponseUnpack")
+)
+
+//LoadNodes 从表格配置读节点,字段为id role host url
+func LoadNodes(parse *config.FileTableParse) []*NodeInfo {
+	nodes := make([]*NodeInfo, 0, parse.Count())
+	for i := 0; i < parse.Count(); i++ {
+		nodes = append(nodes, &NodeInfo{Id: parse.GetItemUint32(i, "id"), Role: parse.GetItem(i, "role"), Host: parse.GetItem(i, "host"), Url: parse.GetItem(i, "url")})
+	}
+	return nodes
+}
+
+//NewClusterPresence 全服在线放在holder节点上,所有节点的holder要一样
+func NewClusterPresence(cluster *Cluster, holder uint32) *ClusterPresence {
+	presence := &ClusterPresence{cluster: cluster, holder: holder, local: make(map[presenceKey]bool), online: make(map[presenceKey]uint32)}
+	presence.init()
+	return presence
+}
+
+//NewCluster selfid为自己的节点ID,必须在nodes里面
+func NewCluster(name string, selfid uint32, nodes []*NodeInfo, maxmsgsize uint32) *Cluster {
+	cluster := &Cluster{name: name, maxmsgsize: maxmsgsize, nodes: make(map[uint32]*NodeInfo), links: make(map[uint32]*session.SessionClient), peers: make(map[uint64]uint32), services: make(map[string]*clusterService), calls: make(map[uint64]*clusterCall), timeout: DefaultCallTimeout, maxpeers: DefaultMaxPeers}
+	for _, node := range nodes {
+		cluster.nodes[node.Id] = node
+	}
+	cluster.self = cluster.nodes[selfid]
+	return cluster
+}
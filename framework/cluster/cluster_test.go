@@ -0,0 +1,345 @@
+//go:build !race
+
+//gnet.WebSocket的状态和发送队列没有加锁,关闭时-race会报,走本地websocket的测试不带-race跑
+
+package cluster
+
+import (
+	"fmt"
+	"g_server/framework/discovery"
+	"g_server/framework/gnet"
+	"g_server/framework/protocolbase"
+	"g_server/framework/session"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	P_test_Echo = uint32(9001)
+	P_test_Item = uint32(9002)
+)
+
+//P_test_item 按pcgtool生成的样子写,Pack总是带协议号,嵌套消息clear传false
+type P_test_item struct {
+	id    uint32
+	count int64
+}
+
+func (self *P_test_item) GetProId() uint32 {
+	return P_test_Item
+}
+
+func (self *P_test_item) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(self.GetProId())
+	packer.PackUInt32(self.id)
+	packer.PackInt64(self.count)
+}
+
+func (self *P_test_item) Unpack(unpacker protocolbase.IUnpacker) int {
+	var ret int
+	ret, self.id = unpacker.UnPackUInt32()
+	if ret != 0 {
+		return ret
+	}
+	ret, self.count = unpacker.UnPackInt64()
+	if ret != 0 {
+		return ret
+	}
+	return 0
+}
+
+type P_test_echo struct {
+	name string
+	item *P_test_item
+}
+
+func (self *P_test_echo) GetProId() uint32 {
+	return P_test_Echo
+}
+
+func (self *P_test_echo) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(self.GetProId())
+	packer.PackString(self.name)
+	self.item.Pack(packer, false)
+}
+
+func (self *P_test_echo) Unpack(unpacker protocolbase.IUnpacker) int {
+	var ret int
+	ret, self.name = unpacker.UnPackString()
+	if ret != 0 {
+		return ret
+	}
+	//嵌套消息的协议号由外层读掉
+	if ret, _ = unpacker.UnPackUInt32(); ret != 0 {
+		return ret
+	}
+	self.item = &P_test_item{}
+	ret = self.item.Unpack(unpacker)
+	if ret != 0 {
+		return ret
+	}
+	return 0
+}
+
+//freeAddr 找一个本地空闲端口
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listen.Close()
+	return listen.Addr().String()
+}
+
+//testNodes 两个节点,1是game,2是db
+func testNodes(t *testing.T) []*NodeInfo {
+	nodes := []*NodeInfo{{Id: 1, Role: "game"}, {Id: 2, Role: "db"}}
+	for _, node := range nodes {
+		node.Host = freeAddr(t)
+		node.Url = fmt.Sprintf("ws://%s/", node.Host)
+	}
+	return nodes
+}
+
+func testCluster(t *testing.T, nodes []*NodeInfo, selfid uint32) *Cluster {
+	t.Helper()
+	cluster := NewCluster(fmt.Sprint("test", selfid), selfid, nodes, 1<<16)
+	if !cluster.Start() {
+		t.Fatal("start fail", selfid)
+	}
+	return cluster
+}
+
+//runUntil 跑所有节点的主循环直到cond成立
+func runUntil(t *testing.T, cond func() bool, clusters ...*Cluster) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		for _, cluster := range clusters {
+			cluster.Run()
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//linked 两个节点互相连上并且对方收到了hello
+func linked(a *Cluster, b *Cluster) func() bool {
+	return func() bool {
+		return len(a.peers) > 0 && len(b.peers) > 0 &&
+			a.links[b.self.Id].Valid() && b.links[a.self.Id].Valid()
+	}
+}
+
+//TestCallGenerated 生成的协议带协议号,请求和返回都要能解出来
+func TestCallGenerated(t *testing.T) {
+	nodes := testNodes(t)
+	game, db := testCluster(t, nodes, 1), testCluster(t, nodes, 2)
+	defer game.Stop()
+	defer db.Stop()
+	db.RegService("echo", func(from uint32, msg protocolbase.IMsg) (protocolbase.IMsg, error) {
+		req := msg.(*P_test_echo)
+		return &P_test_echo{name: fmt.Sprint(req.name, from), item: &P_test_item{id: req.item.id + 1, count: req.item.count * 2}}, nil
+	}, func() protocolbase.IMsg { return &P_test_echo{} })
+	runUntil(t, linked(game, db), game, db)
+
+	var (
+		done bool
+		got  *P_test_echo
+		err  error
+	)
+	game.Call(2, "echo", &P_test_echo{name: "hi", item: &P_test_item{id: 7, count: -21}}, &P_test_echo{}, func(msg protocolbase.IMsg, e error) {
+		done, err = true, e
+		got, _ = msg.(*P_test_echo)
+	})
+	runUntil(t, func() bool { return done }, game, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.name != "hi1" || got.item == nil || got.item.id != 8 || got.item.count != -42 {
+		t.Fatalf("got %+v %+v", got, got.item)
+	}
+}
+
+//TestUnpackBodyId 协议号对不上的包不能当成功
+func TestUnpackBodyId(t *testing.T) {
+	body := packBody(&P_test_item{id: 1, count: 2})
+	if unpackBody(&P_test_echo{}, body) {
+		t.Fatal("unpack with wrong id")
+	}
+	item := &P_test_item{}
+	if !unpackBody(item, body) || item.id != 1 || item.count != 2 {
+		t.Fatalf("unpack %+v", item)
+	}
+}
+
+type failDiscovery struct {
+	deregs int
+}
+
+func (disc *failDiscovery) Register(*discovery.NodeRecord) error {
+	return discovery.ErrNodeRegistered
+}
+
+func (disc *failDiscovery) Deregister(uint32) error {
+	disc.deregs++
+	return nil
+}
+
+func (disc *failDiscovery) List() []*discovery.NodeRecord {
+	return nil
+}
+
+func (disc *failDiscovery) Watch(func(int, *discovery.NodeRecord)) {
+}
+
+//TestStartRegisterFail 注册失败要把监听和连接关掉,端口能再用
+func TestStartRegisterFail(t *testing.T) {
+	nodes := testNodes(t)
+	cluster := NewCluster("test", 1, nodes, 1<<16)
+	disc := &failDiscovery{}
+	cluster.UseDiscovery(disc)
+	if cluster.Start() {
+		t.Fatal("start with register fail")
+	}
+	if len(cluster.links) != 0 || disc.deregs != 0 {
+		t.Fatalf("links %d deregs %d", len(cluster.links), disc.deregs)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		listen, err := net.Listen("tcp", nodes[0].Host)
+		if err == nil {
+			listen.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("listener still open", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//TestCallTimeout 对方不处理,超时回调ErrCallTimeout
+func TestCallTimeout(t *testing.T) {
+	nodes := testNodes(t)
+	game, db := testCluster(t, nodes, 1), testCluster(t, nodes, 2)
+	defer game.Stop()
+	defer db.Stop()
+	db.RegService("echo", func(from uint32, msg protocolbase.IMsg) (protocolbase.IMsg, error) {
+		return msg, nil
+	}, func() protocolbase.IMsg { return &P_test_item{} })
+	runUntil(t, linked(game, db), game, db)
+
+	game.SetCallTimeout(50 * time.Millisecond)
+	var err error
+	done := false
+	game.Call(2, "echo", &P_test_item{id: 1}, &P_test_item{}, func(msg protocolbase.IMsg, e error) {
+		done, err = true, e
+	})
+	//只跑game,db收不到请求
+	runUntil(t, func() bool { return done }, game)
+	if err != ErrCallTimeout {
+		t.Fatalf("err %v", err)
+	}
+	if len(game.calls) != 0 {
+		t.Fatalf("calls left %d", len(game.calls))
+	}
+}
+
+//TestCallNodeLost 连接断了,还没返回的调用马上回调ErrNodeLost
+func TestCallNodeLost(t *testing.T) {
+	nodes := testNodes(t)
+	game, db := testCluster(t, nodes, 1), testCluster(t, nodes, 2)
+	defer game.Stop()
+	defer db.Stop()
+	runUntil(t, linked(game, db), game, db)
+
+	var errs []error
+	for i := 0; i < 3; i++ {
+		game.Call(2, "echo", &P_test_item{id: uint32(i)}, &P_test_item{}, func(msg protocolbase.IMsg, e error) {
+			errs = append(errs, e)
+		})
+	}
+	db.server.KickAll(session.KickCodeMaintain, "")
+	runUntil(t, func() bool { return len(errs) == 3 }, game)
+	for _, err := range errs {
+		if err != ErrNodeLost {
+			t.Fatalf("errs %v", errs)
+		}
+	}
+	if _, err := game.validLink(2); err != ErrNodeInvalid {
+		t.Fatalf("link after lost %v", err)
+	}
+}
+
+//TestPresenceBind 本地绑定和解绑同步到holder,其他节点通过Call查到
+func TestPresenceBind(t *testing.T) {
+	nodes := testNodes(t)
+	game, db := testCluster(t, nodes, 1), testCluster(t, nodes, 2)
+	defer game.Stop()
+	defer db.Stop()
+	holder := NewClusterPresence(db, 2)
+	store := NewClusterPresence(game, 2)
+	runUntil(t, linked(game, db), game, db)
+
+	manager := session.NewSessionManager("front", 16, nil)
+	mem := gnet.NewMemServer(1 << 16)
+	manager.AddListener("mem", mem)
+	presence := session.NewPresence()
+	presence.SetStore(store)
+	manager.UsePresence(presence)
+	if !manager.Start() {
+		t.Fatal("front start fail")
+	}
+	defer manager.Stop()
+	ws := mem.Dial("mem")
+	manager.Run()
+	s := manager.GetSession(ws.ID())
+	if s == nil {
+		t.Fatal("no session")
+	}
+
+	pkey := presenceKey{kind: "uid", key: "u1"}
+	if err := presence.Bind("uid", "u1", s); err != nil {
+		t.Fatal(err)
+	}
+	runUntil(t, func() bool { return holder.online[pkey] == 1 }, game, db)
+
+	var (
+		nodeid uint32
+		found  bool
+		done   bool
+	)
+	query := func(key string) {
+		done = false
+		store.Query("uid", key, func(id uint32, ok bool) {
+			nodeid, found, done = id, ok, true
+		})
+		runUntil(t, func() bool { return done }, game, db)
+	}
+	query("u1")
+	if !found || nodeid != 1 {
+		t.Fatalf("query u1 %d %v", nodeid, found)
+	}
+	query("u2")
+	if found {
+		t.Fatalf("query u2 %d %v", nodeid, found)
+	}
+
+	presence.Unbind("uid", s)
+	runUntil(t, func() bool { _, ok := holder.online[pkey]; return !ok }, game, db)
+	query("u1")
+	if found {
+		t.Fatalf("query after unbind %d %v", nodeid, found)
+	}
+}
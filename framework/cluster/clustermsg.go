@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"g_server/framework/protocolbase"
+)
+
+type clusterMsgHello struct {
+	nodeid uint32
+}
+
+func (msg *clusterMsgHello) GetProId() uint32 {
+	return ClusterMsgIdHello
+}
+
+func (msg *clusterMsgHello) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt32(msg.nodeid)
+}
+
+func (msg *clusterMsgHello) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	r, msg.nodeid = unpacker.UnPackUInt32()
+	return unpacker.InField(r, "nodeid")
+}
+
+//clusterMsgRequest callid为0表示不需要返回,body是请求消息不带消息号的打包数据
+type clusterMsgRequest struct {
+	callid  uint64
+	service string
+	body    []byte
+}
+
+func (msg *clusterMsgRequest) GetProId() uint32 {
+	return ClusterMsgIdRequest
+}
+
+func (msg *clusterMsgRequest) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt64(msg.callid)
+	packer.PackString(msg.service)
+	packer.PackBytes(msg.body)
+}
+
+func (msg *clusterMsgRequest) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	if r, msg.callid = unpacker.UnPackUInt64(); r != 0 {
+		return unpacker.InField(r, "callid")
+	}
+	if r, msg.service = unpacker.UnPackString(); r != 0 {
+		return unpacker.InField(r, "service")
+	}
+	r, msg.body = unpacker.UnPackBytes()
+	return unpacker.InField(r, "body")
+}
+
+//clusterMsgResponse errstr不为空表示调用失败
+type clusterMsgResponse struct {
+	callid uint64
+	errstr string
+	body   []byte
+}
+
+func (msg *clusterMsgResponse) GetProId() uint32 {
+	return ClusterMsgIdResponse
+}
+
+func (msg *clusterMsgResponse) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt64(msg.callid)
+	packer.PackString(msg.errstr)
+	packer.PackBytes(msg.body)
+}
+
+func (msg *clusterMsgResponse) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	if r, msg.callid = unpacker.UnPackUInt64(); r != 0 {
+		return unpacker.InField(r, "callid")
+	}
+	if r, msg.errstr = unpacker.UnPackString(); r != 0 {
+		return unpacker.InField(r, "errstr")
+	}
+	r, msg.body = unpacker.UnPackBytes()
+	return unpacker.InField(r, "body")
+}
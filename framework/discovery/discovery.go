@@ -0,0 +1,21 @@
+package discovery
+
+//NodeRecord 节点记录
+type NodeRecord struct {
+	Id   uint32
+	Role string
+	Host string
+	Url  string
+}
+
+//IDiscovery 服务发现,Watch的回调都在主循环调用
+type IDiscovery interface {
+	Register(*NodeRecord) error
+	Deregister(uint32) error
+	List() []*NodeRecord
+	Watch(func(int, *NodeRecord))
+}
+
+func (node *NodeRecord) equal(other *NodeRecord) bool {
+	return node.Id == other.Id && node.Role == other.Role && node.Host == other.Host && node.Url == other.Url
+}
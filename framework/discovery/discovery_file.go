@@ -0,0 +1,221 @@
+package discovery
+
+import (
+	"bytes"
+	"g_server/framework/com"
+	"g_server/framework/config"
+	"g_server/framework/datastruct"
+	"g_server/framework/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type nodeEvent struct {
+	event int
+	node  *NodeRecord
+}
+
+//FileDiscovery 用共享目录做服务发现,没有etcd的机房用
+type FileDiscovery struct {
+	sync.RWMutex
+	dir        string
+	ttl        time.Duration
+	events     *datastruct.SyncQueue
+	nodes      map[uint32]*NodeRecord
+	heartbeats map[uint32]chan bool
+	watchers   []func(int, *NodeRecord)
+	stop       chan bool
+	name       string
+}
+
+func (disc *FileDiscovery) nodeFile(id uint32) string {
+	return filepath.Join(disc.dir, strconv.FormatUint(uint64(id), 10)+nodeFileExt)
+}
+
+func (disc *FileDiscovery) tick() time.Duration {
+	return disc.ttl / 3
+}
+
+//Register 写节点文件并定时更新修改时间
+func (disc *FileDiscovery) Register(node *NodeRecord) error {
+	disc.Lock()
+	defer disc.Unlock()
+	if _, ok := disc.heartbeats[node.Id]; ok {
+		return ErrNodeRegistered
+	}
+	if err := disc.writeNode(node); err != nil {
+		return err
+	}
+	stop := make(chan bool)
+	disc.heartbeats[node.Id] = stop
+	filename := disc.nodeFile(node.Id)
+	go func() {
+		tick := time.NewTicker(disc.tick())
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-tick.C:
+				if err := os.Chtimes(filename, now, now); err != nil {
+					glog.LogConsole(glog.LogError, "discovery heartbeat fail", filename, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+//Deregister 删掉节点文件
+func (disc *FileDiscovery) Deregister(id uint32) error {
+	disc.Lock()
+	defer disc.Unlock()
+	stop, ok := disc.heartbeats[id]
+	if !ok {
+		return ErrNodeNotFound
+	}
+	close(stop)
+	delete(disc.heartbeats, id)
+	return os.Remove(disc.nodeFile(id))
+}
+
+//List 当前活着的节点
+func (disc *FileDiscovery) List() []*NodeRecord {
+	disc.RLock()
+	defer disc.RUnlock()
+	nodes := make([]*NodeRecord, 0, len(disc.nodes))
+	for _, node := range disc.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+//Watch 节点变化回调,在Run里调用,加入时已经存在的节点马上收到NodeAdd
+//刚启动时同一个节点可能收到两次NodeAdd,回调要能处理重复
+func (disc *FileDiscovery) Watch(f func(int, *NodeRecord)) {
+	disc.watchers = append(disc.watchers, f)
+	for _, node := range disc.List() {
+		com.SafeCall(func() {
+			f(NodeAdd, node)
+		})
+	}
+}
+
+//writeNode 先写临时文件再改名,避免别人读到一半
+func (disc *FileDiscovery) writeNode(node *NodeRecord) error {
+	buf := new(bytes.Buffer)
+	buf.Write(config.UTF8_BOM[:])
+	buf.WriteString("id=" + strconv.FormatUint(uint64(node.Id), 10) + "\n")
+	buf.WriteString("role=" + node.Role + "\n")
+	buf.WriteString("host=" + node.Host + "\n")
+	buf.WriteString("url=" + node.Url + "\n")
+	filename := disc.nodeFile(node.Id)
+	if err := ioutil.WriteFile(filename+".tmp", buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+func (disc *FileDiscovery) readNode(filename string) *NodeRecord {
+	parse := config.NewFileKvParse()
+	if err := config.NewFileReader(parse, config.NewLocalFileStream(filename)).LoadFile(); err != nil {
+		return nil
+	}
+	return &NodeRecord{Id: parse.GetItemUint32("id"), Role: parse.GetItem("role"), Host: parse.GetItem("host"), Url: parse.GetItem("url")}
+}
+
+//scan 扫描目录,和上次比较生成事件
+func (disc *FileDiscovery) scan() {
+	files, err := ioutil.ReadDir(disc.dir)
+	if err != nil {
+		glog.LogConsole(glog.LogError, "discovery scan fail", disc.dir, err)
+		return
+	}
+	now := time.Now()
+	alive := make(map[uint32]*NodeRecord)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), nodeFileExt) || now.Sub(file.ModTime()) > disc.ttl {
+			continue
+		}
+		if node := disc.readNode(filepath.Join(disc.dir, file.Name())); node != nil {
+			alive[node.Id] = node
+		}
+	}
+	disc.Lock()
+	defer disc.Unlock()
+	for id, node := range disc.nodes {
+		if newnode, ok := alive[id]; !ok || !newnode.equal(node) {
+			disc.events.Push(&nodeEvent{event: NodeRemove, node: node})
+		}
+	}
+	for id, node := range alive {
+		if oldnode, ok := disc.nodes[id]; !ok || !oldnode.equal(node) {
+			disc.events.Push(&nodeEvent{event: NodeAdd, node: node})
+		}
+	}
+	disc.nodes = alive
+}
+
+func (disc *FileDiscovery) Run() {
+	for {
+		ievent := disc.events.Pop()
+		if ievent == nil {
+			return
+		}
+		event := ievent.(*nodeEvent)
+		for _, watcher := range disc.watchers {
+			com.SafeCall(func() {
+				watcher(event.event, event.node)
+			})
+		}
+	}
+}
+
+func (disc *FileDiscovery) Start() bool {
+	if err := os.MkdirAll(disc.dir, 0755); err != nil {
+		glog.LogConsole(glog.LogError, "discovery dir fail", disc.dir, err)
+		return false
+	}
+	stop := make(chan bool)
+	disc.stop = stop
+	disc.scan()
+	go func() {
+		tick := time.NewTicker(disc.tick())
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				com.SafeCall(disc.scan)
+			}
+		}
+	}()
+	return true
+}
+
+func (disc *FileDiscovery) Stop() bool {
+	disc.RLock()
+	ids := make([]uint32, 0, len(disc.heartbeats))
+	for id := range disc.heartbeats {
+		ids = append(ids, id)
+	}
+	disc.RUnlock()
+	for _, id := range ids {
+		disc.Deregister(id)
+	}
+	if disc.stop != nil {
+		close(disc.stop)
+		disc.stop = nil
+	}
+	return true
+}
+
+func (disc *FileDiscovery) Name() string {
+	return disc.name
+}
@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func testDiscovery(t *testing.T, ttl time.Duration) *FileDiscovery {
+	t.Helper()
+	disc := NewFileDiscovery("test", t.TempDir(), MinNodeTTL)
+	//测试里直接改短,NewFileDiscovery会限制最小值
+	disc.ttl = ttl
+	if !disc.Start() {
+		t.Fatal("start fail")
+	}
+	t.Cleanup(func() { disc.Stop() })
+	return disc
+}
+
+//collect 跑一次Run,返回收到的事件
+func collect(disc *FileDiscovery) []nodeEvent {
+	var events []nodeEvent
+	//直接换掉回调,不用Watch补发已有节点
+	disc.watchers = []func(int, *NodeRecord){func(event int, node *NodeRecord) {
+		events = append(events, nodeEvent{event: event, node: node})
+	}}
+	disc.Run()
+	return events
+}
+
+//age 把节点文件的修改时间改到d之前
+func age(t *testing.T, disc *FileDiscovery, id uint32, d time.Duration) {
+	t.Helper()
+	past := time.Now().Add(-d)
+	if err := os.Chtimes(disc.nodeFile(id), past, past); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//TestTTLClamp ttl太短用MinNodeTTL,心跳间隔不能为0
+func TestTTLClamp(t *testing.T) {
+	for _, ttl := range []time.Duration{0, time.Nanosecond, time.Second} {
+		disc := NewFileDiscovery("test", t.TempDir(), ttl)
+		if disc.ttl != MinNodeTTL || disc.tick() <= 0 {
+			t.Fatalf("ttl %v -> %v tick %v", ttl, disc.ttl, disc.tick())
+		}
+	}
+	if disc := NewFileDiscovery("test", t.TempDir(), time.Minute); disc.ttl != time.Minute {
+		t.Fatalf("ttl %v", disc.ttl)
+	}
+}
+
+//TestRegisterHeartbeat 注册后定时刷新修改时间,重复注册报错
+func TestRegisterHeartbeat(t *testing.T) {
+	disc := testDiscovery(t, 300*time.Millisecond)
+	node := &NodeRecord{Id: 1, Role: "game", Host: "127.0.0.1:9001", Url: "ws://127.0.0.1:9001/"}
+	if err := disc.Register(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := disc.Register(node); err != ErrNodeRegistered {
+		t.Fatalf("register twice %v", err)
+	}
+	age(t, disc, 1, time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := os.Stat(disc.nodeFile(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if time.Since(info.ModTime()) < time.Minute {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("heartbeat not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	disc.scan()
+	if nodes := disc.List(); len(nodes) != 1 || !nodes[0].equal(node) {
+		t.Fatalf("list %v", nodes)
+	}
+}
+
+//TestTTLExpire 没有心跳的节点超过ttl就移除
+func TestTTLExpire(t *testing.T) {
+	disc := testDiscovery(t, time.Minute)
+	//别的进程注册的节点,这里没有心跳
+	other := &NodeRecord{Id: 2, Role: "db", Host: "h", Url: "u"}
+	if err := disc.writeNode(other); err != nil {
+		t.Fatal(err)
+	}
+	disc.scan()
+	if events := collect(disc); len(events) != 1 || events[0].event != NodeAdd || !events[0].node.equal(other) {
+		t.Fatalf("events %v", events)
+	}
+	age(t, disc, 2, 2*time.Minute)
+	disc.scan()
+	if events := collect(disc); len(events) != 1 || events[0].event != NodeRemove || events[0].node.Id != 2 {
+		t.Fatalf("events %v", events)
+	}
+	if nodes := disc.List(); len(nodes) != 0 {
+		t.Fatalf("list %v", nodes)
+	}
+}
+
+//TestWatchReplay Watch时已经存在的节点马上收到NodeAdd
+func TestWatchReplay(t *testing.T) {
+	disc := testDiscovery(t, time.Minute)
+	for id := uint32(1); id <= 3; id++ {
+		if err := disc.Register(&NodeRecord{Id: id, Role: "game"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	disc.scan()
+	seen := make(map[uint32]int)
+	disc.Watch(func(event int, node *NodeRecord) {
+		if event == NodeAdd {
+			seen[node.Id]++
+		}
+	})
+	if len(seen) != 3 || seen[1] != 1 || seen[2] != 1 || seen[3] != 1 {
+		t.Fatalf("seen %v", seen)
+	}
+}
+
+//TestDeregister 删掉节点文件停止心跳,扫描后移除
+func TestDeregister(t *testing.T) {
+	disc := testDiscovery(t, time.Minute)
+	if err := disc.Register(&NodeRecord{Id: 1, Role: "game"}); err != nil {
+		t.Fatal(err)
+	}
+	disc.scan()
+	collect(disc)
+	if err := disc.Deregister(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := disc.Deregister(1); err != ErrNodeNotFound {
+		t.Fatalf("deregister twice %v", err)
+	}
+	if _, err := os.Stat(disc.nodeFile(1)); !os.IsNotExist(err) {
+		t.Fatalf("node file %v", err)
+	}
+	disc.scan()
+	if events := collect(disc); len(events) != 1 || events[0].event != NodeRemove {
+		t.Fatalf("events %v", events)
+	}
+	//注销后可以重新注册
+	if err := disc.Register(&NodeRecord{Id: 1, Role: "game"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,32 @@
+package discovery
+
+import (
+	"errors"
+	"g_server/framework/datastruct"
+	"g_server/framework/log"
+	"time"
+)
+
+const (
+	NodeAdd    = 1
+	NodeRemove = 2
+
+	//MinNodeTTL 心跳间隔是ttl/3,有的共享文件系统修改时间只精确到秒,再短判断不准
+	MinNodeTTL = 3 * time.Second
+
+	nodeFileExt = ".node"
+)
+
+var (
+	ErrNodeRegistered = errors.New("Err NodeRegistered")
+	ErrNodeNotFound   = errors.New("Err NodeNotFound")
+)
+
+//NewFileDiscovery dir为所有节点共享的目录,每个节点一个文件,文件超过ttl没更新就认为节点没了
+func NewFileDiscovery(name string, dir string, ttl time.Duration) *FileDiscovery {
+	if ttl < MinNodeTTL {
+		glog.LogConsole(glog.LogWarning, "discovery ttl too short, use", MinNodeTTL, name, ttl)
+		ttl = MinNodeTTL
+	}
+	return &FileDiscovery{name: name, dir: dir, ttl: ttl, events: datastruct.NewSyncQueue(), nodes: make(map[uint32]*NodeRecord), heartbeats: make(map[uint32]chan bool)}
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"g_server/framework/session"
+)
+
+//gateSession 网关转发过来的客户端会话,ID是网关上的会话ID
+//连了多个网关的话ID可能重复,需要用Link区分
+type gateSession struct {
+	link     session.ISession
+	sid      uint64
+	ip       string
+	mode     int
+	tag      interface{}
+	errcount session.MsgErrCount
+	attrs    session.AttrStore
+}
+
+func (s *gateSession) ID() uint64 {
+	return s.sid
+}
+
+//Link 所在的网关连接
+func (s *gateSession) Link() session.ISession {
+	return s.link
+}
+
+func (s *gateSession) SendMsg(msg protocolbase.IMsg) {
+	packer := msgpack.PopPacker()
+	defer msgpack.PushPacker(packer)
+	packer.ClearBuffer()
+	packer.SetMode(s.CodecMode())
+	msg.Pack(packer, true)
+	s.SendBytes(packer.GetBuffer())
+}
+
+func (s *gateSession) SendBytes(data []byte) {
+	s.link.SendMsg(&gateMsgData{sid: s.sid, data: data})
+}
+
+func (s *gateSession) IpStr() string {
+	return s.ip
+}
+
+func (s *gateSession) Listener() string {
+	return s.link.Listener()
+}
+
+func (s *gateSession) SetTag(tag interface{}) {
+	s.tag = tag
+}
+
+func (s *gateSession) GetTag() interface{} {
+	return s.tag
+}
+
+func (s *gateSession) ErrCount() session.MsgErrCount {
+	return s.errcount
+}
+
+func (s *gateSession) Attrs() *session.AttrStore {
+	return &s.attrs
+}
+
+//CodecMode 客户端连网关用的编码方式,打开会话时网关带过来
+func (s *gateSession) CodecMode() int {
+	return s.mode
+}
+
+//Backend 后端服务器上把网关连接拆成客户端会话,业务消息注册在Backend上
+type Backend struct {
+	*session.SessionMsgProxy
+	manager *session.SessionManager
+	links   map[uint64]map[uint64]*gateSession
+}
+
+func (backend *Backend) init() {
+	backend.manager.RegIMsgHandler(GateMsgIdOpen, func(link session.ISession, msg protocolbase.IMsg, ok bool) {
+		if ok {
+			backend.onOpen(link, msg.(*gateMsgOpen))
+		}
+	}, func() protocolbase.IMsg { return &gateMsgOpen{} })
+	backend.manager.RegIMsgHandler(GateMsgIdClose, func(link session.ISession, msg protocolbase.IMsg, ok bool) {
+		if ok {
+			backend.onClose(link, msg.(*gateMsgClose).sid)
+		}
+	}, func() protocolbase.IMsg { return &gateMsgClose{} })
+	backend.manager.RegIMsgHandler(GateMsgIdData, func(link session.ISession, msg protocolbase.IMsg, ok bool) {
+		if ok {
+			backend.onData(link, msg.(*gateMsgData))
+		}
+	}, func() protocolbase.IMsg { return &gateMsgData{} })
+	backend.manager.RegSessionClose(backend.onLinkClose)
+}
+
+//GetSession 找网关上的会话
+func (backend *Backend) GetSession(link session.ISession, sid uint64) session.ISession {
+	if s, ok := backend.links[link.ID()][sid]; ok {
+		return s
+	}
+	return nil
+}
+
+//Kick 通知网关踢掉客户端
+func (backend *Backend) Kick(s session.ISession) {
+	gs, ok := s.(*gateSession)
+	if !ok {
+		return
+	}
+	gs.link.SendMsg(&gateMsgClose{sid: gs.sid})
+	backend.onClose(gs.link, gs.sid)
+}
+
+func (backend *Backend) onOpen(link session.ISession, msg *gateMsgOpen) {
+	sessions, ok := backend.links[link.ID()]
+	if !ok {
+		sessions = make(map[uint64]*gateSession)
+		backend.links[link.ID()] = sessions
+	}
+	if _, ok := sessions[msg.sid]; ok || !msgpack.ValidMode(int(msg.mode)) {
+		return
+	}
+	s := &gateSession{link: link, sid: msg.sid, ip: msg.ip, mode: int(msg.mode)}
+	sessions[msg.sid] = s
+	backend.NotifyOpen(s)
+}
+
+func (backend *Backend) onClose(link session.ISession, sid uint64) {
+	sessions := backend.links[link.ID()]
+	if s, ok := sessions[sid]; ok {
+		delete(sessions, sid)
+		backend.NotifyClose(s)
+	}
+}
+
+func (backend *Backend) onData(link session.ISession, msg *gateMsgData) {
+	if s, ok := backend.links[link.ID()][msg.sid]; ok {
+		backend.Dispatch(s, &s.errcount, msg.data)
+	}
+}
+
+//onLinkClose 网关断开,上面的会话全部关闭
+func (backend *Backend) onLinkClose(link session.ISession) {
+	sessions, ok := backend.links[link.ID()]
+	if !ok {
+		return
+	}
+	delete(backend.links, link.ID())
+	for _, s := range sessions {
+		backend.NotifyClose(s)
+	}
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"g_server/framework/protocolbase"
+)
+
+type gateMsgOpen struct {
+	sid  uint64
+	ip   string
+	mode uint8
+}
+
+func (msg *gateMsgOpen) GetProId() uint32 {
+	return GateMsgIdOpen
+}
+
+func (msg *gateMsgOpen) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt64(msg.sid)
+	packer.PackString(msg.ip)
+	packer.PackUInt8(msg.mode)
+}
+
+func (msg *gateMsgOpen) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	if r, msg.sid = unpacker.UnPackUInt64(); r != 0 {
+		return unpacker.InField(r, "sid")
+	}
+	if r, msg.ip = unpacker.UnPackString(); r != 0 {
+		return unpacker.InField(r, "ip")
+	}
+	r, msg.mode = unpacker.UnPackUInt8()
+	return unpacker.InField(r, "mode")
+}
+
+type gateMsgClose struct {
+	sid uint64
+}
+
+func (msg *gateMsgClose) GetProId() uint32 {
+	return GateMsgIdClose
+}
+
+func (msg *gateMsgClose) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt64(msg.sid)
+}
+
+func (msg *gateMsgClose) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	r, msg.sid = unpacker.UnPackUInt64()
+	return unpacker.InField(r, "sid")
+}
+
+//gateMsgData data是完整的客户端消息,包括消息号
+type gateMsgData struct {
+	sid  uint64
+	data []byte
+}
+
+func (msg *gateMsgData) GetProId() uint32 {
+	return GateMsgIdData
+}
+
+func (msg *gateMsgData) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt64(msg.sid)
+	packer.PackBytes(msg.data)
+}
+
+func (msg *gateMsgData) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	if r, msg.sid = unpacker.UnPackUInt64(); r != 0 {
+		return unpacker.InField(r, "sid")
+	}
+	r, msg.data = unpacker.UnPackBytes()
+	return unpacker.InField(r, "data")
+}
@@ -0,0 +1,295 @@
+package gateway
+
+import (
+	"g_server/framework/discovery"
+	"g_server/framework/log"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"g_server/framework/session"
+	"strconv"
+)
+
+type gateRoute struct {
+	minid   uint32
+	maxid   uint32
+	backend string
+}
+
+//gateClient 客户端会话在网关上的数据
+type gateClient struct {
+	bind   string
+	opened map[string]bool
+}
+
+//Gateway 网关,客户端消息没有在front上注册的都转发到后端
+//先按消息号段找后端,找不到再用会话绑定的后端
+type Gateway struct {
+	front        *session.SessionManager
+	backends     map[string]*session.SessionClient
+	routes       []gateRoute
+	clients      map[uint64]*gateClient
+	fclientOpen  func(session.ISession)
+	fclientClose func(session.ISession)
+	watches      []*gateWatch
+	name         string
+}
+
+//gateWatch 从服务发现取某个角色的后端
+type gateWatch struct {
+	disc       discovery.IDiscovery
+	role       string
+	rcontime   int32
+	maxmsgsize uint32
+}
+
+//Front 面向客户端的SessionManager,可以注册网关自己处理的消息
+func (gate *Gateway) Front() *session.SessionManager {
+	return gate.front
+}
+
+//AddBackend 添加一个后端,Start之前调用
+func (gate *Gateway) AddBackend(name string, curl string, rcontime int32, maxmsgsize uint32) bool {
+	if _, ok := gate.backends[name]; ok {
+		return false
+	}
+	client := session.NewWsSessionClient(gate.name+"."+name, curl, rcontime, maxmsgsize)
+	client.RegIMsgHandler(GateMsgIdData, func(s session.ISession, msg protocolbase.IMsg, ok bool) {
+		if ok {
+			gate.onBackendData(msg.(*gateMsgData))
+		}
+	}, func() protocolbase.IMsg { return &gateMsgData{} })
+	client.RegIMsgHandler(GateMsgIdClose, func(s session.ISession, msg protocolbase.IMsg, ok bool) {
+		if ok {
+			gate.onBackendClose(name, msg.(*gateMsgClose).sid)
+		}
+	}, func() protocolbase.IMsg { return &gateMsgClose{} })
+	client.RegSessionClose(func(s session.ISession) {
+		gate.onBackendLost(name)
+	})
+	gate.backends[name] = client
+	return true
+}
+
+//WatchBackends 某个角色的节点自动作为后端,名字为role.id,Start之前调用
+func (gate *Gateway) WatchBackends(disc discovery.IDiscovery, role string, rcontime int32, maxmsgsize uint32) {
+	gate.watches = append(gate.watches, &gateWatch{disc: disc, role: role, rcontime: rcontime, maxmsgsize: maxmsgsize})
+}
+
+func (gate *Gateway) onDiscovery(watch *gateWatch, event int, node *discovery.NodeRecord) {
+	if node.Role != watch.role {
+		return
+	}
+	name := node.Role + "." + strconv.FormatUint(uint64(node.Id), 10)
+	switch event {
+	case discovery.NodeAdd:
+		if gate.AddBackend(name, node.Url, watch.rcontime, watch.maxmsgsize) {
+			gate.backends[name].Start()
+		}
+	case discovery.NodeRemove:
+		gate.RemoveBackend(name)
+	}
+}
+
+//RemoveBackend 去掉后端,绑定在上面的客户端会被踢掉
+func (gate *Gateway) RemoveBackend(name string) bool {
+	backend, ok := gate.backends[name]
+	if !ok {
+		return false
+	}
+	delete(gate.backends, name)
+	backend.Stop()
+	gate.onBackendLost(name)
+	return true
+}
+
+//AddRoute 消息号在[minid,maxid]的都转发到backend
+func (gate *Gateway) AddRoute(minid uint32, maxid uint32, backend string) {
+	gate.routes = append(gate.routes, gateRoute{minid: minid, maxid: maxid, backend: backend})
+}
+
+//RegClientOpen 客户端连上网关
+func (gate *Gateway) RegClientOpen(f func(session.ISession)) {
+	gate.fclientOpen = f
+}
+
+//RegClientClose 客户端断开网关
+func (gate *Gateway) RegClientClose(f func(session.ISession)) {
+	gate.fclientClose = f
+}
+
+//Bind 把会话绑定到后端,号段路由找不到的消息都发到这里
+func (gate *Gateway) Bind(sid uint64, backend string) bool {
+	client, ok := gate.clients[sid]
+	if !ok {
+		return false
+	}
+	if _, ok := gate.backends[backend]; !ok {
+		return false
+	}
+	client.bind = backend
+	return true
+}
+
+//Migrate 把会话迁移到另一个后端,旧后端会收到关闭,新后端马上收到连接
+func (gate *Gateway) Migrate(sid uint64, backend string) bool {
+	client, ok := gate.clients[sid]
+	if !ok {
+		return false
+	}
+	target, ok := gate.backends[backend]
+	if !ok {
+		return false
+	}
+	if client.bind != "" && client.bind != backend {
+		gate.closeBackend(sid, client, client.bind)
+	}
+	client.bind = backend
+	if target.Valid() {
+		gate.openBackend(sid, client, backend, target)
+	}
+	return true
+}
+
+//BindName 会话绑定的后端
+func (gate *Gateway) BindName(sid uint64) string {
+	if client, ok := gate.clients[sid]; ok {
+		return client.bind
+	}
+	return ""
+}
+
+func (gate *Gateway) route(client *gateClient, msgid uint32) string {
+	for _, route := range gate.routes {
+		if msgid >= route.minid && msgid <= route.maxid {
+			return route.backend
+		}
+	}
+	return client.bind
+}
+
+func (gate *Gateway) openBackend(sid uint64, client *gateClient, name string, backend *session.SessionClient) {
+	if client.opened[name] {
+		return
+	}
+	client.opened[name] = true
+	ip, mode := "", msgpack.ModeLegacy
+	if s := gate.front.GetSession(sid); s != nil {
+		ip, mode = s.IpStr(), s.CodecMode()
+	}
+	backend.SendMsg(&gateMsgOpen{sid: sid, ip: ip, mode: uint8(mode)})
+}
+
+func (gate *Gateway) closeBackend(sid uint64, client *gateClient, name string) {
+	if !client.opened[name] {
+		return
+	}
+	delete(client.opened, name)
+	if backend, ok := gate.backends[name]; ok && backend.Valid() {
+		backend.SendMsg(&gateMsgClose{sid: sid})
+	}
+}
+
+//forward 前端收到的消息,返回true表示已经转发
+func (gate *Gateway) forward(s session.ISession, msgid uint32, data []byte) bool {
+	if gate.front.FindMsgProxy(msgid) != nil {
+		return false
+	}
+	client, ok := gate.clients[s.ID()]
+	if !ok {
+		return false
+	}
+	name := gate.route(client, msgid)
+	backend, ok := gate.backends[name]
+	if !ok || !backend.Valid() {
+		glog.LogConsole(glog.LogWarning, "gateway no backend", s.ID(), msgid, name)
+		return true
+	}
+	gate.openBackend(s.ID(), client, name, backend)
+	backend.SendMsg(&gateMsgData{sid: s.ID(), data: data})
+	return true
+}
+
+func (gate *Gateway) onClientOpen(s session.ISession) {
+	gate.clients[s.ID()] = &gateClient{opened: make(map[string]bool)}
+	if gate.fclientOpen != nil {
+		gate.fclientOpen(s)
+	}
+}
+
+func (gate *Gateway) onClientClose(s session.ISession) {
+	if client, ok := gate.clients[s.ID()]; ok {
+		for name := range client.opened {
+			gate.closeBackend(s.ID(), client, name)
+		}
+		delete(gate.clients, s.ID())
+	}
+	if gate.fclientClose != nil {
+		gate.fclientClose(s)
+	}
+}
+
+func (gate *Gateway) onBackendData(msg *gateMsgData) {
+	if s := gate.front.GetSession(msg.sid); s != nil {
+		s.SendBytes(msg.data)
+	}
+}
+
+//onBackendClose 后端要求关闭,绑定的后端关闭就踢掉客户端
+func (gate *Gateway) onBackendClose(name string, sid uint64) {
+	client, ok := gate.clients[sid]
+	if !ok {
+		return
+	}
+	delete(client.opened, name)
+	if client.bind == name {
+		gate.front.KickWithReason(sid, session.KickCodeBackend, "backend closed")
+	}
+}
+
+//onBackendLost 后端断开,上面的状态都没了,绑定在上面的客户端踢掉
+func (gate *Gateway) onBackendLost(name string) {
+	glog.LogConsole(glog.LogWarning, "gateway backend lost", name)
+	for sid, client := range gate.clients {
+		if !client.opened[name] {
+			continue
+		}
+		delete(client.opened, name)
+		if client.bind == name {
+			gate.front.KickWithReason(sid, session.KickCodeBackend, "backend lost")
+		}
+	}
+}
+
+func (gate *Gateway) Run() {
+	gate.front.Run()
+	for _, backend := range gate.backends {
+		backend.Run()
+	}
+}
+
+func (gate *Gateway) Start() bool {
+	for _, backend := range gate.backends {
+		backend.Start()
+	}
+	gate.front.RegRawMsg(gate.forward)
+	gate.front.RegSessionOpen(gate.onClientOpen)
+	gate.front.RegSessionClose(gate.onClientClose)
+	for _, watch := range gate.watches {
+		watch := watch
+		watch.disc.Watch(func(event int, node *discovery.NodeRecord) {
+			gate.onDiscovery(watch, event, node)
+		})
+	}
+	return gate.front.Start()
+}
+
+func (gate *Gateway) Stop() bool {
+	for _, backend := range gate.backends {
+		backend.Stop()
+	}
+	return gate.front.Stop()
+}
+
+func (gate *Gateway) Name() string {
+	return gate.name
+}
@@ -0,0 +1,25 @@
+package gateway
+
+import (
+	"g_server/framework/session"
+)
+
+const (
+	//网关和后端之间的消息号,业务消息不能使用这个段
+	GateMsgIdBase  = uint32(0xfffe0000)
+	GateMsgIdOpen  = GateMsgIdBase + 1 //客户端会话开始走这个后端
+	GateMsgIdClose = GateMsgIdBase + 2 //会话关闭,两个方向都有
+	GateMsgIdData  = GateMsgIdBase + 3 //转发的消息
+)
+
+//NewGateway front为面向客户端的SessionManager,后端用AddBackend添加
+func NewGateway(name string, front *session.SessionManager) *Gateway {
+	return &Gateway{name: name, front: front, backends: make(map[string]*session.SessionClient), clients: make(map[uint64]*gateClient)}
+}
+
+//NewBackend 后端服务器用,manager为接受网关连接的SessionManager
+func NewBackend(manager *session.SessionManager) *Backend {
+	backend := &Backend{SessionMsgProxy: session.NewSessionMsgProxy(), manager: manager, links: make(map[uint64]map[uint64]*gateSession)}
+	backend.init()
+	return backend
+}
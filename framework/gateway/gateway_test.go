@@ -0,0 +1,227 @@
+//go:build !race
+
+//gnet.WebSocket的状态和发送队列没有加锁,关闭时-race会报,走本地websocket的测试不带-race跑
+
+package gateway
+
+import (
+	"fmt"
+	"g_server/framework/gnet"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"g_server/framework/session"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMsgId = uint32(100)
+
+type testMsg struct {
+	value uint32
+}
+
+func (msg *testMsg) GetProId() uint32 {
+	return testMsgId
+}
+
+func (msg *testMsg) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt32(msg.value)
+}
+
+func (msg *testMsg) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	r, msg.value = unpacker.UnPackUInt32()
+	return unpacker.InField(r, "value")
+}
+
+func packTest(value uint32) []byte {
+	packer := msgpack.PopPacker()
+	defer msgpack.PushPacker(packer)
+	(&testMsg{value: value}).Pack(packer, true)
+	return append([]byte(nil), packer.GetBuffer()...)
+}
+
+func unpackTest(data []byte) (uint32, bool) {
+	unpacker := msgpack.PopUnPacker()
+	defer msgpack.PushUnPacker(unpacker)
+	unpacker.Attatch(data)
+	if r, id := unpacker.UnPackUInt32(); r != 0 || id != testMsgId {
+		return 0, false
+	}
+	msg := &testMsg{}
+	return msg.value, msg.Unpack(unpacker) == 0
+}
+
+//testEnv 一个网关连一个后端,客户端走MemServer,网关和后端之间走本地websocket
+type testEnv struct {
+	gate    *Gateway
+	mem     *gnet.MemServer
+	manager *session.SessionManager
+	backend *Backend
+	opens   []session.ISession
+	closes  []session.ISession
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listen.Addr().String()
+	listen.Close()
+
+	env := &testEnv{mem: gnet.NewMemServer(1 << 16)}
+	env.manager = session.NewWsSessionManager("back", addr, 1<<16, 16, nil)
+	env.backend = NewBackend(env.manager)
+	env.backend.RegSessionOpen(func(s session.ISession) { env.opens = append(env.opens, s) })
+	env.backend.RegSessionClose(func(s session.ISession) { env.closes = append(env.closes, s) })
+	//后端把值加1发回去
+	env.backend.RegIMsgHandler(testMsgId, func(s session.ISession, msg protocolbase.IMsg, ok bool) {
+		if ok {
+			s.SendMsg(&testMsg{value: msg.(*testMsg).value + 1})
+		}
+	}, func() protocolbase.IMsg { return &testMsg{} })
+	if !env.manager.Start() {
+		t.Fatal("backend start fail")
+	}
+
+	front := session.NewSessionManager("front", 16, nil)
+	front.AddListener("mem", env.mem)
+	env.gate = NewGateway("gate", front)
+	env.gate.AddBackend("game", fmt.Sprintf("ws://%s/", addr), 1, 1<<16)
+	if !env.gate.Start() {
+		t.Fatal("gateway start fail")
+	}
+	t.Cleanup(func() {
+		env.gate.Stop()
+		env.manager.Stop()
+	})
+	env.runUntil(t, env.gate.backends["game"].Valid)
+	return env
+}
+
+func (env *testEnv) runUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		env.gate.Run()
+		env.manager.Run()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//testClient 客户端收到的包
+type testClient struct {
+	sync.Mutex
+	ws   *gnet.MemSocket
+	recv [][]byte
+}
+
+func (env *testEnv) dial(t *testing.T) (*testClient, uint64) {
+	t.Helper()
+	client := &testClient{ws: env.mem.Dial("10.0.0.1")}
+	client.ws.SetSendHook(func(data []byte) {
+		client.Lock()
+		client.recv = append(client.recv, data)
+		client.Unlock()
+	})
+	sid := client.ws.ID()
+	env.runUntil(t, func() bool { return env.gate.clients[sid] != nil })
+	return client, sid
+}
+
+func (client *testClient) count() int {
+	client.Lock()
+	defer client.Unlock()
+	return len(client.recv)
+}
+
+//checkKickCode 客户端要知道是后端的原因被踢
+func checkKickCode(t *testing.T, ws *gnet.MemSocket) {
+	t.Helper()
+	if code, _ := ws.CloseCode(); code != uint16(4000+session.KickCodeBackend) {
+		t.Fatalf("close code %d", code)
+	}
+}
+
+//TestForward 第一条消息才在后端打开会话,两个方向都能转发,客户端断开后端收到关闭
+func TestForward(t *testing.T) {
+	env := newTestEnv(t)
+	env.gate.AddRoute(testMsgId, testMsgId, "game")
+	client, sid := env.dial(t)
+	for i := 0; i < 10; i++ {
+		env.gate.Run()
+		env.manager.Run()
+	}
+	if len(env.opens) != 0 {
+		t.Fatal("backend opened before first message")
+	}
+
+	client.ws.Recv(packTest(41))
+	env.runUntil(t, func() bool { return client.count() == 1 })
+	if len(env.opens) != 1 || env.opens[0].IpStr() != "10.0.0.1" {
+		t.Fatalf("opens %v", env.opens)
+	}
+	if value, ok := unpackTest(client.recv[0]); !ok || value != 42 {
+		t.Fatalf("client got %v %v", value, ok)
+	}
+	//再发不会重复打开
+	client.ws.Recv(packTest(1))
+	env.runUntil(t, func() bool { return client.count() == 2 })
+	if len(env.opens) != 1 {
+		t.Fatalf("opened %d times", len(env.opens))
+	}
+
+	client.ws.Close()
+	env.runUntil(t, func() bool { return len(env.closes) == 1 })
+	if env.gate.clients[sid] != nil {
+		t.Fatal("client not removed")
+	}
+}
+
+//TestBackendKick 后端踢掉绑定的会话,网关把客户端踢掉
+func TestBackendKick(t *testing.T) {
+	env := newTestEnv(t)
+	client, sid := env.dial(t)
+	if !env.gate.Bind(sid, "game") {
+		t.Fatal("bind fail")
+	}
+	client.ws.Recv(packTest(1))
+	env.runUntil(t, func() bool { return len(env.opens) == 1 })
+	env.backend.Kick(env.opens[0])
+	env.runUntil(t, func() bool { return client.ws.State() == gnet.WsStateClosed })
+	checkKickCode(t, client.ws)
+	if len(env.closes) != 1 {
+		t.Fatalf("backend closes %d", len(env.closes))
+	}
+}
+
+//TestBackendLost 后端连接断了,打开过的绑定会话被踢,没打开过的不动
+func TestBackendLost(t *testing.T) {
+	env := newTestEnv(t)
+	opened, sid := env.dial(t)
+	idle, idlesid := env.dial(t)
+	env.gate.Bind(sid, "game")
+	env.gate.Bind(idlesid, "game")
+	opened.ws.Recv(packTest(1))
+	env.runUntil(t, func() bool { return opened.count() == 1 })
+
+	env.manager.KickAll(session.KickCodeMaintain, "")
+	env.runUntil(t, func() bool { return opened.ws.State() == gnet.WsStateClosed })
+	checkKickCode(t, opened.ws)
+	if idle.ws.State() == gnet.WsStateClosed {
+		t.Fatal("idle client kicked")
+	}
+	if len(env.closes) != 1 {
+		t.Fatalf("backend closes %d", len(env.closes))
+	}
+}
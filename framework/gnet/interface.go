@@ -7,6 +7,7 @@ type ISocket interface {
 	ID() uint64
 	State() int
 	Close() bool
+	CloseWithCode(uint16, string) bool
 	Start() bool
 	SendBit([]byte)
 	SetWatcher(ISocketWatcher)
@@ -0,0 +1,189 @@
+package gnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//MemServer 内存监听,不走网络,回放和测试用
+//Dial出来的连接收发都在调用者的协程里同步执行
+type MemServer struct {
+	maxmsgsize uint32
+	connid     uint64
+	state      int32
+	watcher    IServerWatcher
+}
+
+func (server *MemServer) TypeName() string {
+	return "memserver"
+}
+
+func (server *MemServer) SetMaxMsgSize(size uint32) {
+	server.maxmsgsize = size
+}
+
+func (server *MemServer) GetMaxMsgSize() uint32 {
+	return server.maxmsgsize
+}
+
+func (server *MemServer) SetWatcher(watcher IServerWatcher) {
+	server.watcher = watcher
+}
+
+func (server *MemServer) GetWatcher() IServerWatcher {
+	return server.watcher
+}
+
+func (server *MemServer) Start() bool {
+	return atomic.CompareAndSwapInt32(&server.state, WsServerStateClosed, WsServerListenning)
+}
+
+func (server *MemServer) Stop() bool {
+	return atomic.CompareAndSwapInt32(&server.state, WsServerListenning, WsServerStateClosed)
+}
+
+//Dial 模拟一个客户端连上来,没有Start返回nil
+func (server *MemServer) Dial(remote string) *MemSocket {
+	if atomic.LoadInt32(&server.state) != WsServerListenning {
+		return nil
+	}
+	ws := &MemSocket{remote: remote, connid: atomic.AddUint64(&server.connid, 1), state: WsStateConnecting, maxmsgsize: server.maxmsgsize}
+	if server.watcher != nil {
+		server.watcher.OnSocketAccept(ws)
+	}
+	ws.Start()
+	return ws
+}
+
+//MemSocket 内存连接,Recv模拟收到客户端消息,服务器发出去的消息交给SetSendHook设置的函数
+type MemSocket struct {
+	sync.Mutex
+	remote     string
+	connid     uint64
+	state      int
+	maxmsgsize uint32
+	watcher    ISocketWatcher
+	fsend      func([]byte)
+
+	closecode   uint16
+	closereason string
+}
+
+func (ws *MemSocket) TypeName() string {
+	return "memsocket"
+}
+
+func (ws *MemSocket) LocalAddr() string {
+	return "mem"
+}
+
+func (ws *MemSocket) RemoteAddr() string {
+	return ws.remote
+}
+
+func (ws *MemSocket) ID() uint64 {
+	return ws.connid
+}
+
+func (ws *MemSocket) State() int {
+	ws.Lock()
+	defer ws.Unlock()
+	return ws.state
+}
+
+func (ws *MemSocket) SetWatcher(watcher ISocketWatcher) {
+	ws.Lock()
+	defer ws.Unlock()
+	ws.watcher = watcher
+}
+
+func (ws *MemSocket) GetWatcher() ISocketWatcher {
+	ws.Lock()
+	defer ws.Unlock()
+	return ws.watcher
+}
+
+//SetSendHook 服务器发出的消息,data已经复制过可以保存
+func (ws *MemSocket) SetSendHook(f func([]byte)) {
+	ws.Lock()
+	defer ws.Unlock()
+	ws.fsend = f
+}
+
+func (ws *MemSocket) Start() bool {
+	ws.Lock()
+	if ws.state != WsStateConnecting {
+		ws.Unlock()
+		return false
+	}
+	ws.state = WsStateConnected
+	watcher := ws.watcher
+	ws.Unlock()
+	if watcher != nil {
+		watcher.OnSocketOpen(ws)
+	}
+	return true
+}
+
+//CloseWithCode 内存连接没有关闭帧,记下关闭码
+func (ws *MemSocket) CloseWithCode(code uint16, reason string) bool {
+	ws.Lock()
+	ws.closecode = code
+	ws.closereason = reason
+	ws.Unlock()
+	return ws.Close()
+}
+
+//CloseCode 服务器关闭时给的关闭码
+func (ws *MemSocket) CloseCode() (uint16, string) {
+	ws.Lock()
+	defer ws.Unlock()
+	return ws.closecode, ws.closereason
+}
+
+//Close 两边谁关闭都一样,回调在锁外调用,回调里可以再操作连接
+func (ws *MemSocket) Close() bool {
+	ws.Lock()
+	if ws.state == WsStateClosed {
+		ws.Unlock()
+		return false
+	}
+	ws.state = WsStateClosed
+	watcher := ws.watcher
+	ws.Unlock()
+	if watcher != nil {
+		watcher.OnSocketClose(ws)
+	}
+	return true
+}
+
+func (ws *MemSocket) SendBit(data []byte) {
+	ws.Lock()
+	fsend := ws.fsend
+	connected := ws.state == WsStateConnected
+	ws.Unlock()
+	if connected && fsend != nil {
+		out := make([]byte, len(data))
+		copy(out, data)
+		fsend(out)
+	}
+}
+
+//Recv 模拟收到客户端消息,超过最大包大小的直接断开
+func (ws *MemSocket) Recv(data []byte) bool {
+	ws.Lock()
+	watcher := ws.watcher
+	connected := ws.state == WsStateConnected
+	ws.Unlock()
+	if !connected {
+		return false
+	}
+	if ws.maxmsgsize > 0 && uint32(len(data)) > ws.maxmsgsize {
+		ws.Close()
+		return false
+	}
+	if watcher != nil {
+		watcher.OnSocketMessage(ws, data)
+	}
+	return true
+}
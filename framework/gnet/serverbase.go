@@ -1,8 +1,10 @@
 package gnet
 
 import (
+	"crypto/tls"
 	"g_server/framework/log"
 	"net"
+	"sync/atomic"
 )
 
 //BaseServer 服务器
@@ -10,20 +12,20 @@ type BaseServer struct {
 	connid   uint64
 	host     string
 	listen   net.Listener
-	state    int
+	state    int32
 	fnewConn func(conn net.Conn)
 	network  string
+	tlsconf  *tls.Config
 }
 
 //Stop 关闭
 func (server *BaseServer) Stop() bool {
-	//这里不会多线程调用三，不管了不加锁
-	if server.state == WsServerStateClosed || server.state == WsServerStateCloseing {
+	//accept协程退出时也会调用
+	if !atomic.CompareAndSwapInt32(&server.state, WsServerListenning, WsServerStateCloseing) {
 		return false
 	}
-	server.state = WsServerStateCloseing
 	err := server.listen.Close()
-	server.state = WsServerStateClosed
+	atomic.StoreInt32(&server.state, WsServerStateClosed)
 	if err != nil {
 		glog.LogConsole(glog.LogError, "close server err", err)
 	}
@@ -37,13 +39,24 @@ func (server *BaseServer) Start() bool {
 		glog.LogConsole(glog.LogError, "start server fail", err)
 		return false
 	}
+	if server.tlsconf != nil {
+		listen = tls.NewListener(listen, server.tlsconf)
+	}
 	server.listen = listen
-	server.state = WsServerListenning
+	atomic.StoreInt32(&server.state, WsServerListenning)
 	server.accept()
 	glog.LogConsole(glog.LogInfo, "start server")
 	return true
 }
 
+//Addr 实际监听的地址,端口填0时用这个取,没有Start返回空
+func (server *BaseServer) Addr() string {
+	if server.listen == nil {
+		return ""
+	}
+	return server.listen.Addr().String()
+}
+
 func (server *BaseServer) accept() {
 	go func() {
 		defer server.Stop()
@@ -53,7 +66,7 @@ func (server *BaseServer) accept() {
 				glog.LogConsole(glog.LogError, "server accept", err)
 				return
 			}
-			if server.state != WsServerListenning {
+			if atomic.LoadInt32(&server.state) != WsServerListenning {
 				return
 			}
 			if server.fnewConn != nil {
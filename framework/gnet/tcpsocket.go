@@ -0,0 +1,279 @@
+package gnet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"g_server/framework/log"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+//TcpServer 裸tcp监听,不需要websocket的原生客户端用
+type TcpServer struct {
+	BaseServer
+	maxmsgsize uint32
+	watcher    IServerWatcher
+}
+
+func (server *TcpServer) TypeName() string {
+	return "tcpserver"
+}
+
+//SetMaxMsgSize 设置接受最大包大小
+func (server *TcpServer) SetMaxMsgSize(size uint32) {
+	server.maxmsgsize = size
+}
+
+//GetMaxMsgSize 返回接受最大包大小
+func (server *TcpServer) GetMaxMsgSize() uint32 {
+	return server.maxmsgsize
+}
+
+func (server *TcpServer) SetWatcher(watcher IServerWatcher) {
+	server.watcher = watcher
+}
+
+func (server *TcpServer) GetWatcher() IServerWatcher {
+	return server.watcher
+}
+
+//Start 开启
+func (server *TcpServer) Start() bool {
+	server.fnewConn = server.newTcpSocket
+	if server.network == "" {
+		server.network = "tcp"
+	}
+	return server.BaseServer.Start()
+}
+
+func (server *TcpServer) newTcpSocket(conn net.Conn) {
+	ws := &TcpSocket{conn: conn, state: WsStateConnecting, connid: server.genConnid(), maxmsgsize: server.maxmsgsize}
+	if server.watcher != nil {
+		server.watcher.OnSocketAccept(ws)
+	}
+	ws.Start()
+}
+
+//TcpSocket 裸tcp连接,每个包前面是4字节大端的长度
+//收发各一个协程,关闭时先把排队的消息发完再断开
+type TcpSocket struct {
+	sync.Mutex
+	conn       net.Conn
+	connid     uint64
+	state      int
+	sendchan   chan []byte
+	maxmsgsize uint32
+	watcher    ISocketWatcher
+}
+
+func (ws *TcpSocket) TypeName() string {
+	return "tcpsocket"
+}
+
+func (ws *TcpSocket) LocalAddr() string {
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.conn == nil {
+		return ""
+	}
+	return ws.conn.LocalAddr().String()
+}
+
+func (ws *TcpSocket) RemoteAddr() string {
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.conn == nil {
+		return ""
+	}
+	return ws.conn.RemoteAddr().String()
+}
+
+//ID 返回ID
+func (ws *TcpSocket) ID() uint64 {
+	return ws.connid
+}
+
+//State 返回状态
+func (ws *TcpSocket) State() int {
+	ws.Lock()
+	defer ws.Unlock()
+	return ws.state
+}
+
+func (ws *TcpSocket) SetWatcher(watcher ISocketWatcher) {
+	ws.Lock()
+	defer ws.Unlock()
+	ws.watcher = watcher
+}
+
+func (ws *TcpSocket) GetWatcher() ISocketWatcher {
+	ws.Lock()
+	defer ws.Unlock()
+	return ws.watcher
+}
+
+//Start 开始收发
+func (ws *TcpSocket) Start() bool {
+	ws.Lock()
+	if ws.state != WsStateConnecting || ws.conn == nil {
+		ws.Unlock()
+		return false
+	}
+	ws.state = WsStateConnected
+	sendchan := make(chan []byte, _tcpSendChanSize)
+	ws.sendchan = sendchan
+	conn := ws.conn
+	ws.Unlock()
+	ws.beginSend(conn, sendchan)
+	ws.beginRecv(conn, sendchan)
+	return true
+}
+
+//Close 关闭连接,前面排队的消息会先发完
+func (ws *TcpSocket) Close() bool {
+	ws.Lock()
+	defer ws.Unlock()
+	switch ws.state {
+	case WsStateConnected:
+		ws.state = WsStateCloseing
+		close(ws.sendchan)
+		ws.sendchan = nil
+	case WsStateConnecting:
+		ws.state = WsStateClosed
+		if ws.conn != nil {
+			ws.conn.Close()
+		}
+	}
+	return true
+}
+
+//CloseWithCode 裸tcp没有关闭帧,关闭码不发给对方
+//踢人原因靠session在关闭前发的踢人消息,排队的消息会先发完,所以对方一定能收到
+func (ws *TcpSocket) CloseWithCode(code uint16, reason string) bool {
+	return ws.Close()
+}
+
+//SendBit 发送二进制,队列满了说明对方收得太慢,直接断开,不能卡住调用方
+func (ws *TcpSocket) SendBit(data []byte) {
+	//拷贝一份避免外面修改slice
+	_data := make([]byte, len(data))
+	copy(_data, data)
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.state != WsStateConnected {
+		return
+	}
+	select {
+	case ws.sendchan <- _data:
+	default:
+		glog.LogConsole(glog.LogError, "tcp send:", ErrSendOverflow, ws.connid)
+		ws.state = WsStateCloseing
+		close(ws.sendchan)
+		ws.sendchan = nil
+		//排队的也不发了,关掉连接让收发协程都退出
+		ws.conn.Close()
+	}
+}
+
+//closeRecv 收数据出错时关闭,重连以后旧协程不能关掉新连接
+func (ws *TcpSocket) closeRecv(sendchan chan []byte) {
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.state == WsStateConnected && ws.sendchan == sendchan {
+		ws.state = WsStateCloseing
+		close(ws.sendchan)
+		ws.sendchan = nil
+	}
+}
+
+func (ws *TcpSocket) beginRecv(conn net.Conn, sendchan chan []byte) {
+	go func() {
+		defer ws.closeRecv(sendchan)
+		if watcher := ws.GetWatcher(); watcher != nil {
+			watcher.OnSocketOpen(ws)
+		}
+		rd := bufio.NewReader(conn)
+		head := make([]byte, _tcpHeadSize)
+		for {
+			if _, err := io.ReadFull(rd, head); err != nil {
+				glog.LogConsole(glog.LogInfo, "tcp read end:", err)
+				return
+			}
+			size := binary.BigEndian.Uint32(head)
+			if ws.maxmsgsize > 0 && size > ws.maxmsgsize {
+				glog.LogConsole(glog.LogError, "tcp recv:", ErrMsgSizeInvalid, size)
+				return
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(rd, data); err != nil {
+				glog.LogConsole(glog.LogInfo, "tcp read end:", err)
+				return
+			}
+			if watcher := ws.GetWatcher(); watcher != nil {
+				watcher.OnSocketMessage(ws, data)
+			}
+		}
+	}()
+}
+
+func (ws *TcpSocket) beginSend(conn net.Conn, sendchan chan []byte) {
+	go func() {
+		wr := bufio.NewWriter(conn)
+		head := make([]byte, _tcpHeadSize)
+		var err error
+		for data := range sendchan {
+			//写失败以后连接已经断了,剩下的扔掉,等收协程关掉队列
+			if err != nil {
+				continue
+			}
+			binary.BigEndian.PutUint32(head, uint32(len(data)))
+			conn.SetWriteDeadline(time.Now().Add(_tcpWriteTimeout))
+			if _, err = wr.Write(head); err == nil {
+				_, err = wr.Write(data)
+			}
+			//队列里没有了再刷,连续的小包合成一次写
+			if err == nil && len(sendchan) == 0 {
+				err = wr.Flush()
+			}
+			if err != nil {
+				glog.LogConsole(glog.LogError, "tcp write err:", err)
+				conn.Close()
+			}
+		}
+		err = conn.Close()
+		ws.Lock()
+		ws.state = WsStateClosed
+		watcher := ws.watcher
+		ws.Unlock()
+		if watcher != nil {
+			watcher.OnSocketClose(ws)
+		}
+		glog.LogConsole(glog.LogInfo, "close TcpSocket:", err)
+	}()
+}
+
+//TcpClient 裸tcp客户端,断开以后可以再Start重连
+type TcpClient struct {
+	TcpSocket
+	host    string
+	network string
+}
+
+//Start 连接,已经连着的返回false
+func (ws *TcpClient) Start() bool {
+	if ws.State() != WsStateClosed {
+		return false
+	}
+	conn, err := net.Dial(ws.network, ws.host)
+	if err != nil {
+		glog.LogConsole(glog.LogError, "dial fail", err)
+		return false
+	}
+	ws.Lock()
+	ws.conn = conn
+	ws.state = WsStateConnecting
+	ws.Unlock()
+	return ws.TcpSocket.Start()
+}
@@ -0,0 +1,193 @@
+package gnet
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testWatcher 收到的消息和关闭通知放到chan里
+type testWatcher struct {
+	sync.Mutex
+	sockets chan ISocket
+	msgs    chan []byte
+	closed  chan bool
+	echo    bool
+}
+
+func newTestWatcher(echo bool) *testWatcher {
+	return &testWatcher{sockets: make(chan ISocket, 16), msgs: make(chan []byte, 1024), closed: make(chan bool, 16), echo: echo}
+}
+
+func (watcher *testWatcher) OnSocketAccept(ws ISocket) {
+	ws.SetWatcher(watcher)
+	watcher.sockets <- ws
+}
+
+func (watcher *testWatcher) OnSocketOpen(ws ISocket) {
+}
+
+func (watcher *testWatcher) OnSocketClose(ws ISocket) {
+	watcher.closed <- true
+}
+
+func (watcher *testWatcher) OnSocketMessage(ws ISocket, data []byte) {
+	if watcher.echo {
+		ws.SendBit(data)
+		return
+	}
+	watcher.msgs <- data
+}
+
+func testTcpServer(t *testing.T, maxmsgsize uint32, watcher *testWatcher) *TcpServer {
+	t.Helper()
+	server := NewTcpServer("127.0.0.1:0", maxmsgsize)
+	server.SetWatcher(watcher)
+	if !server.Start() {
+		t.Fatal("start fail")
+	}
+	t.Cleanup(func() { server.Stop() })
+	return server
+}
+
+func testTcpClient(t *testing.T, server *TcpServer, watcher *testWatcher) *TcpClient {
+	t.Helper()
+	client := NewTcpClient(server.Addr(), 1<<20)
+	client.SetWatcher(watcher)
+	if !client.Start() {
+		t.Fatal("dial fail")
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func waitMsg(t *testing.T, msgs chan []byte) []byte {
+	t.Helper()
+	select {
+	case data := <-msgs:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	return nil
+}
+
+func waitClose(t *testing.T, closed chan bool) {
+	t.Helper()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close timeout")
+	}
+}
+
+//TestTcpEcho 分包要按长度切开,空包和大包都要原样回来
+func TestTcpEcho(t *testing.T) {
+	server := testTcpServer(t, 1<<20, newTestWatcher(true))
+	watcher := newTestWatcher(false)
+	client := testTcpClient(t, server, watcher)
+	sends := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte{7}, 300000), []byte("end")}
+	for _, data := range sends {
+		client.SendBit(data)
+	}
+	for _, data := range sends {
+		if got := waitMsg(t, watcher.msgs); !bytes.Equal(got, data) {
+			t.Fatalf("echo len %d, want %d", len(got), len(data))
+		}
+	}
+}
+
+//TestTcpMaxMsgSize 超过最大包大小服务器直接断开
+func TestTcpMaxMsgSize(t *testing.T) {
+	server := testTcpServer(t, 16, newTestWatcher(true))
+	watcher := newTestWatcher(false)
+	client := testTcpClient(t, server, watcher)
+	client.SendBit(make([]byte, 17))
+	waitClose(t, watcher.closed)
+	if client.State() != WsStateClosed {
+		t.Fatalf("state %d", client.State())
+	}
+}
+
+//TestTcpCloseFlush 关闭前排队的消息要先发出去
+func TestTcpCloseFlush(t *testing.T) {
+	serverWatcher := newTestWatcher(false)
+	server := testTcpServer(t, 1<<20, serverWatcher)
+	watcher := newTestWatcher(false)
+	testTcpClient(t, server, watcher)
+	var ws ISocket
+	select {
+	case ws = <-serverWatcher.sockets:
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept timeout")
+	}
+	for i := 0; i < 50; i++ {
+		ws.SendBit([]byte{byte(i)})
+	}
+	ws.CloseWithCode(1, "bye")
+	ws.SendBit([]byte("after close"))
+	for i := 0; i < 50; i++ {
+		if got := waitMsg(t, watcher.msgs); len(got) != 1 || got[0] != byte(i) {
+			t.Fatalf("msg %d got %v", i, got)
+		}
+	}
+	waitClose(t, watcher.closed)
+	waitClose(t, serverWatcher.closed)
+	select {
+	case data := <-watcher.msgs:
+		t.Fatalf("got message after close %q", data)
+	default:
+	}
+}
+
+//TestTcpReconnect 客户端断开以后可以再Start
+func TestTcpReconnect(t *testing.T) {
+	server := testTcpServer(t, 1<<20, newTestWatcher(true))
+	watcher := newTestWatcher(false)
+	client := testTcpClient(t, server, watcher)
+	if client.Start() {
+		t.Fatal("start while connected")
+	}
+	client.Close()
+	waitClose(t, watcher.closed)
+	if !client.Start() {
+		t.Fatal("reconnect fail")
+	}
+	client.SendBit([]byte("again"))
+	if got := waitMsg(t, watcher.msgs); string(got) != "again" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+//TestTcpSendOverflow 对方不收,队列满了SendBit不能卡住,连接直接断开
+func TestTcpSendOverflow(t *testing.T) {
+	serverWatcher := newTestWatcher(false)
+	server := testTcpServer(t, 1<<20, serverWatcher)
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var ws ISocket
+	select {
+	case ws = <-serverWatcher.sockets:
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept timeout")
+	}
+	done := make(chan bool)
+	go func() {
+		data := make([]byte, 1<<20)
+		for i := 0; i < _tcpSendChanSize*4 && ws.State() == WsStateConnected; i++ {
+			ws.SendBit(data)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendBit blocked on a slow peer")
+	}
+	waitClose(t, serverWatcher.closed)
+}
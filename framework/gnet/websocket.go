@@ -79,11 +79,25 @@ func (ws *WebSocket) State() int {
 
 //Close 关闭连接
 func (ws *WebSocket) Close() bool {
+	return ws.closeFrame([]byte("close"))
+}
+
+//CloseWithCode 关闭帧带上关闭码和原因,前面排队的消息会先发完
+func (ws *WebSocket) CloseWithCode(code uint16, reason string) bool {
+	if len(reason) > _wsCloseReasonMax {
+		reason = reason[:_wsCloseReasonMax]
+	}
+	data := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(data, code)
+	return ws.closeFrame(append(data, reason...))
+}
+
+func (ws *WebSocket) closeFrame(data []byte) bool {
 	glog.LogConsole(glog.LogInfo, "state", ws.state)
 	if ws.state == WsStateClosed || ws.state == WsStateCloseing {
 		return true
 	}
-	ws.pushMsgChan(_wsOpcodeClose, []byte("close"))
+	ws.pushMsgChan(_wsOpcodeClose, data)
 	ws.state = WsStateCloseing
 	close(ws.sendchan)
 	return true
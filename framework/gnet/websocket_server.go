@@ -39,7 +39,9 @@ func (server *WebSocketServer) GetWatcher() IServerWatcher {
 //Start 开启
 func (server *WebSocketServer) Start() bool {
 	server.fnewConn = server.newWebSocket
-	server.network = "tcp"
+	if server.network == "" {
+		server.network = "tcp"
+	}
 	return server.BaseServer.Start()
 }
 
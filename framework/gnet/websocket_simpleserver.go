@@ -2,6 +2,7 @@ package gnet
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 //WebSocketServerSimple 服务器
@@ -99,7 +100,7 @@ func (server *WebSocketServerSimple) Start() bool {
 
 //ClientCount 连接个数
 func (server *WebSocketServerSimple) ClientCount() int {
-	if server.state != WsServerListenning {
+	if atomic.LoadInt32(&server.state) != WsServerListenning {
 		return 0
 	}
 	server.RLock()
@@ -1,7 +1,9 @@
 package gnet
 
 import (
+	"crypto/tls"
 	"errors"
+	"time"
 )
 
 const (
@@ -25,6 +27,8 @@ const (
 	_wsOpcodePing  = byte(0x9)
 	_wsOpcodePong  = byte(0xA)
 
+	_wsCloseReasonMax = 123 //控制帧最多125字节,去掉2字节关闭码
+
 	WsStateClosed     = 0
 	WsStateCloseing   = 1
 	WsStateConnecting = 2
@@ -33,6 +37,10 @@ const (
 	WsServerStateClosed   = 0
 	WsServerStateCloseing = 1
 	WsServerListenning    = 2
+
+	_tcpHeadSize     = 4
+	_tcpSendChanSize = 100
+	_tcpWriteTimeout = 10 * time.Second
 )
 
 var (
@@ -44,6 +52,7 @@ var (
 	ErrInvalidOpcode  = errors.New("Err InvalidOpcode")
 	ErrHandshakeEmpty = errors.New("Err ErrHandshakeEmpty")
 	ErrHandshake      = errors.New("Err Handshake")
+	ErrSendOverflow   = errors.New("Err SendOverflow")
 )
 
 //webSocketMsg 发送消息使用
@@ -57,6 +66,16 @@ func NewWebSocketServer(shost string, maxmsgsize uint32) *WebSocketServer {
 	return &WebSocketServer{BaseServer: BaseServer{host: shost}, wsmaxmsgsize: maxmsgsize}
 }
 
+//NewWebSocketTLSServer 生成一个wss服务器
+func NewWebSocketTLSServer(shost string, maxmsgsize uint32, tlsconf *tls.Config) *WebSocketServer {
+	return &WebSocketServer{BaseServer: BaseServer{host: shost, network: "tcp", tlsconf: tlsconf}, wsmaxmsgsize: maxmsgsize}
+}
+
+//NewWebSocketUnixServer 生成一个监听unix socket的服务器,spath为文件路径
+func NewWebSocketUnixServer(spath string, maxmsgsize uint32) *WebSocketServer {
+	return &WebSocketServer{BaseServer: BaseServer{host: spath, network: "unix"}, wsmaxmsgsize: maxmsgsize}
+}
+
 //NewWebSocketServerSimple 生成一个服务器
 func NewWebSocketServerSimple(shost string, maxmsgsize uint32) *WebSocketServerSimple {
 	return &WebSocketServerSimple{WebSocketServer: WebSocketServer{BaseServer: BaseServer{host: shost}, wsmaxmsgsize: maxmsgsize}}
@@ -71,3 +90,23 @@ func NewWebSocketClient(curl string, maxmsgsize uint32) *WebSocketClient {
 func NewWebSocketIpv6Client(curl string, maxmsgsize uint32) *WebSocketClient {
 	return &WebSocketClient{WebSocket: WebSocket{wsmaxmsgsize: maxmsgsize}, hosturl: curl, network: "tcp6"}
 }
+
+//NewTcpServer 裸tcp服务器,包前面是4字节大端长度
+func NewTcpServer(shost string, maxmsgsize uint32) *TcpServer {
+	return &TcpServer{BaseServer: BaseServer{host: shost, network: "tcp"}, maxmsgsize: maxmsgsize}
+}
+
+//NewTcpTLSServer 裸tcp加tls
+func NewTcpTLSServer(shost string, maxmsgsize uint32, tlsconf *tls.Config) *TcpServer {
+	return &TcpServer{BaseServer: BaseServer{host: shost, network: "tcp", tlsconf: tlsconf}, maxmsgsize: maxmsgsize}
+}
+
+//NewTcpClient 裸tcp客户端,shost是ip:port
+func NewTcpClient(shost string, maxmsgsize uint32) *TcpClient {
+	return &TcpClient{TcpSocket: TcpSocket{maxmsgsize: maxmsgsize}, host: shost, network: "tcp"}
+}
+
+//NewMemServer 内存监听,回放和测试用
+func NewMemServer(maxmsgsize uint32) *MemServer {
+	return &MemServer{maxmsgsize: maxmsgsize}
+}
@@ -1,9 +1,7 @@
 package msgpack
 
 import (
-	"bytes"
 	"encoding/binary"
-	"g_server/framework/log"
 	"math"
 )
 
@@ -43,6 +41,27 @@ const (
 	MP_RAW32  = uint8(0xdb)
 	MP_FIXRAW = uint8(0xa0) //!< Last 5 bits is size
 
+	//! Strings,raw就是str16/str32
+	MP_STR8   = uint8(0xd9)
+	MP_STR16  = MP_RAW16
+	MP_STR32  = MP_RAW32
+	MP_FIXSTR = MP_FIXRAW
+
+	//! Binary
+	MP_BIN8  = uint8(0xc4)
+	MP_BIN16 = uint8(0xc5)
+	MP_BIN32 = uint8(0xc6)
+
+	//! Extension
+	MP_EXT8     = uint8(0xc7)
+	MP_EXT16    = uint8(0xc8)
+	MP_EXT32    = uint8(0xc9)
+	MP_FIXEXT1  = uint8(0xd4)
+	MP_FIXEXT2  = uint8(0xd5)
+	MP_FIXEXT4  = uint8(0xd6)
+	MP_FIXEXT8  = uint8(0xd7)
+	MP_FIXEXT16 = uint8(0xd8)
+
 	/*****************************************************
 	 * Container types
 	 *****************************************************/
@@ -70,45 +89,97 @@ const (
 	PackbuffSize = 512
 )
 
-var (
-	USE_BIGENDIAN = false
+//编码方式,整数,浮点数和长度字段的字节序不同
+const (
+	ModeLegacy = 0 //以前的小端格式,只给还没升级的客户端用
+	ModeSpec   = 1 //标准MessagePack,大端,其他语言的库都能解
 )
 
+//Packer 直接追加到切片上,打包过程不分配额外的内存
 type Packer struct {
-	out_ *bytes.Buffer
+	buf  []byte
+	enc  *Encoder
+	mode int
+	//反射打包时指针,map和slice的层数,很深的时候才开始记地址找环
+	reflevel int
+	refseen  map[packRef]bool
+}
+
+//SetMode ModeLegacy或者ModeSpec,默认ModeLegacy
+func (packer *Packer) SetMode(mode int) {
+	packer.mode = mode
+}
+
+//ValidMode 是不是ModeLegacy或者ModeSpec
+func ValidMode(mode int) bool {
+	return mode == ModeLegacy || mode == ModeSpec
+}
+
+func (packer *Packer) Mode() int {
+	return packer.mode
+}
+
+//spill 流式打包时缓冲够多了就写出去
+func (packer *Packer) spill() {
+	if packer.enc != nil && len(packer.buf) >= packer.enc.flushsize {
+		packer.enc.Flush()
+	}
+}
+
+func (packer *Packer) put8(value uint8) *Packer {
+	packer.buf = append(packer.buf, value)
+	return packer
+}
+
+func (packer *Packer) put16(value uint16) *Packer {
+	if packer.mode == ModeSpec {
+		packer.buf = binary.BigEndian.AppendUint16(packer.buf, value)
+	} else {
+		packer.buf = binary.LittleEndian.AppendUint16(packer.buf, value)
+	}
+	return packer
 }
 
-func (packer *Packer) write(i interface{}) *Packer {
-	if USE_BIGENDIAN {
-		binary.Write(packer.out_, binary.BigEndian, i)
-		return packer
+func (packer *Packer) put32(value uint32) *Packer {
+	if packer.mode == ModeSpec {
+		packer.buf = binary.BigEndian.AppendUint32(packer.buf, value)
+	} else {
+		packer.buf = binary.LittleEndian.AppendUint32(packer.buf, value)
+	}
+	return packer
+}
+
+func (packer *Packer) put64(value uint64) *Packer {
+	if packer.mode == ModeSpec {
+		packer.buf = binary.BigEndian.AppendUint64(packer.buf, value)
+	} else {
+		packer.buf = binary.LittleEndian.AppendUint64(packer.buf, value)
 	}
-	binary.Write(packer.out_, binary.LittleEndian, i)
 	return packer
 }
 
 func (packer *Packer) packInt64(value int64) *Packer {
 	if value >= 0 {
 		if value <= int64(MAX_7BIT) {
-			packer.write(uint8(value) | MP_FIXNUM)
+			packer.put8(uint8(value) | MP_FIXNUM)
 		} else if value <= int64(MAX_15BIT) {
-			packer.write(MP_INT16).write(int16(value))
+			packer.put8(MP_INT16).put16(uint16(value))
 		} else if value <= int64(MAX_31BIT) {
-			packer.write(MP_INT32).write(int32(value))
+			packer.put8(MP_INT32).put32(uint32(value))
 		} else {
-			packer.write(MP_INT64).write(value)
+			packer.put8(MP_INT64).put64(uint64(value))
 		}
 	} else {
 		if value >= -(int64(MAX_5BIT) + 1) {
-			packer.write(int8(int16(value) | int16(MP_NEGATIVE_FIXNUM)))
+			packer.put8(uint8(int8(value)) | MP_NEGATIVE_FIXNUM)
 		} else if value >= -(int64(MAX_7BIT) + 1) {
-			packer.write(MP_INT8).write(int8(value))
+			packer.put8(MP_INT8).put8(uint8(int8(value)))
 		} else if value >= -(int64(MAX_15BIT) + 1) {
-			packer.write(MP_INT16).write(int16(value))
+			packer.put8(MP_INT16).put16(uint16(int16(value)))
 		} else if value >= -(int64(MAX_31BIT) + 1) {
-			packer.write(MP_INT32).write(int32(value))
+			packer.put8(MP_INT32).put32(uint32(int32(value)))
 		} else {
-			packer.write(MP_INT64).write(value)
+			packer.put8(MP_INT64).put64(uint64(value))
 		}
 	}
 	return packer
@@ -116,397 +187,672 @@ func (packer *Packer) packInt64(value int64) *Packer {
 
 func (packer *Packer) packUInt64(value uint64) *Packer {
 	if value <= uint64(MAX_7BIT) {
-		packer.write(int8(value) | int8(MP_FIXNUM))
+		packer.put8(uint8(value) | MP_FIXNUM)
 	} else if value <= uint64(MAX_8BIT) {
-		packer.write(MP_UINT8).write(uint8(value))
+		packer.put8(MP_UINT8).put8(uint8(value))
 	} else if value <= uint64(MAX_16BIT) {
-		packer.write(MP_UINT16).write(uint16(value))
+		packer.put8(MP_UINT16).put16(uint16(value))
 	} else if value <= uint64(MAX_32BIT) {
-		packer.write(MP_UINT32).write(uint32(value))
+		packer.put8(MP_UINT32).put32(uint32(value))
 	} else {
-		packer.write(MP_UINT64).write(value)
+		packer.put8(MP_UINT64).put64(value)
 	}
 	return packer
 }
 
-func (packer *Packer) packBytes(value []byte) {
+//packStr 旧格式没有str8,32到255字节的也用raw16
+func (packer *Packer) packStr(value string) {
 	length := uint32(len(value))
 	if length <= MAX_5BIT {
-		packer.write(int8(uint8(length) | MP_FIXRAW))
+		packer.put8(uint8(length) | MP_FIXSTR)
+	} else if length <= MAX_8BIT && packer.mode == ModeSpec {
+		packer.put8(MP_STR8).put8(uint8(length))
+	} else if length <= MAX_16BIT {
+		packer.put8(MP_STR16).put16(uint16(length))
+	} else {
+		packer.put8(MP_STR32).put32(length)
+	}
+	packer.buf = append(packer.buf, value...)
+}
+
+//packBin 旧格式没有bin,和字符串一样打成raw
+func (packer *Packer) packBin(value []byte) {
+	length := uint32(len(value))
+	if packer.mode != ModeSpec {
+		if length <= MAX_5BIT {
+			packer.put8(uint8(length) | MP_FIXRAW)
+		} else if length <= MAX_16BIT {
+			packer.put8(MP_RAW16).put16(uint16(length))
+		} else {
+			packer.put8(MP_RAW32).put32(length)
+		}
+	} else if length <= MAX_8BIT {
+		packer.put8(MP_BIN8).put8(uint8(length))
 	} else if length <= MAX_16BIT {
-		packer.write(MP_RAW16).write(int16(length))
+		packer.put8(MP_BIN16).put16(uint16(length))
 	} else {
-		packer.write(MP_RAW32).write(int32(length))
+		packer.put8(MP_BIN32).put32(length)
 	}
-	packer.out_.Write(value)
+	packer.buf = append(packer.buf, value...)
 }
 
+//packContainer fix是fixarray或者fixmap,长度16位以内用h16
+func (packer *Packer) packContainer(length uint32, fix uint8, h16 uint8, h32 uint8) {
+	if length <= MAX_4BIT {
+		packer.put8(uint8(length) | fix)
+	} else if length <= MAX_16BIT {
+		packer.put8(h16).put16(uint16(length))
+	} else {
+		packer.put8(h32).put32(length)
+	}
+}
+
+//GetBuffer 返回的切片在下次打包或ClearBuffer之前有效
 func (packer *Packer) GetBuffer() []byte {
-	return packer.out_.Bytes()
+	return packer.buf
 }
 
 func (packer *Packer) ClearBuffer() {
-	packer.out_.Reset()
+	packer.buf = packer.buf[:0]
 }
 
 func (packer *Packer) PackFloat(value float32) {
-	glog.LogConsole(glog.LogInfo, "PackFloat", value)
-	packer.write(MP_FLOAT).write(math.Float32bits(value))
+	packer.put8(MP_FLOAT).put32(math.Float32bits(value))
+	packer.spill()
 }
 
 func (packer *Packer) PackDouble(value float64) {
-	glog.LogConsole(glog.LogInfo, "PackDouble", value)
-	packer.write(MP_DOUBLE).write(math.Float64bits(value))
+	packer.put8(MP_DOUBLE).put64(math.Float64bits(value))
+	packer.spill()
 }
 
 func (packer *Packer) PackBool(value bool) {
-	glog.LogConsole(glog.LogInfo, "PackBool", value)
 	if value {
-		packer.write(MP_TRUE)
-		return
+		packer.put8(MP_TRUE)
+	} else {
+		packer.put8(MP_FALSE)
 	}
-	packer.write(MP_FALSE)
+	packer.spill()
 }
 
 func (packer *Packer) PackBytes(value []byte) {
-	glog.LogConsole(glog.LogInfo, "PackBytes", value)
-	packer.packBytes(value)
+	packer.packBin(value)
+	packer.spill()
 }
 
 func (packer *Packer) PackString(value string) {
-	glog.LogConsole(glog.LogInfo, "PackString", value)
-	packer.packBytes([]byte(value))
+	packer.packStr(value)
+	packer.spill()
+}
+
+func (packer *Packer) PackNil() {
+	packer.put8(MP_NULL)
+	packer.spill()
+}
+
+//PackArrayHeader 后面跟着length个元素
+func (packer *Packer) PackArrayHeader(length uint32) {
+	packer.packContainer(length, MP_FIXARRAY, MP_ARRAY16, MP_ARRAY32)
+	packer.spill()
+}
+
+//PackMapHeader 后面跟着length对键值
+func (packer *Packer) PackMapHeader(length uint32) {
+	packer.packContainer(length, MP_FIXMAP, MP_MAP16, MP_MAP32)
+	packer.spill()
+}
+
+func (packer *Packer) PackInt8(value int8) {
+	packer.packInt64(int64(value))
+	packer.spill()
+}
+
+func (packer *Packer) PackInt16(value int16) {
+	packer.packInt64(int64(value))
+	packer.spill()
 }
 
 func (packer *Packer) PackInt32(value int32) {
-	glog.LogConsole(glog.LogInfo, "PackInt32", value)
 	packer.packInt64(int64(value))
+	packer.spill()
 }
 
 func (packer *Packer) PackInt64(value int64) {
-	glog.LogConsole(glog.LogInfo, "PackInt64", value)
 	packer.packInt64(value)
+	packer.spill()
+}
+
+func (packer *Packer) PackUInt8(value uint8) {
+	packer.packUInt64(uint64(value))
+	packer.spill()
+}
+
+func (packer *Packer) PackUInt16(value uint16) {
+	packer.packUInt64(uint64(value))
+	packer.spill()
 }
 
 func (packer *Packer) PackUInt32(value uint32) {
-	glog.LogConsole(glog.LogInfo, "PackUInt32", value)
 	packer.packUInt64(uint64(value))
+	packer.spill()
 }
 
 func (packer *Packer) PackUInt64(value uint64) {
-	glog.LogConsole(glog.LogInfo, "PackUInt64", value)
 	packer.packUInt64(value)
+	packer.spill()
 }
 
+//UnPacker 直接在Attatch的数据上解码,不复制也不装箱
 type UnPacker struct {
-	in_ *bytes.Buffer
+	in  []byte
+	pos int
+	src *decodeSource
+
+	//出错时用,base是流式解码已经丢掉的字节数
+	base   int
+	mark   int
+	header uint8
+	index  int
+	cause  error
+	err    *DecodeError
+
+	limits DecodeLimits
+	depth  int
+	mode   int
+}
+
+//SetMode 要和打包时一样
+func (unpacker *UnPacker) SetMode(mode int) {
+	unpacker.mode = mode
+}
+
+func (unpacker *UnPacker) Mode() int {
+	return unpacker.mode
+}
+
+//next 不够n个字节时把剩下的都丢掉
+func (unpacker *UnPacker) next(n int) ([]byte, bool) {
+	if n < 0 || len(unpacker.in)-unpacker.pos < n && !unpacker.fill(n) {
+		unpacker.pos = len(unpacker.in)
+		unpacker.setCause(ErrDecodeShort)
+		return nil, false
+	}
+	data := unpacker.in[unpacker.pos : unpacker.pos+n]
+	unpacker.pos += n
+	return data, true
+}
+
+func (unpacker *UnPacker) get8() (uint8, bool) {
+	if unpacker.pos >= len(unpacker.in) && !unpacker.fill(1) {
+		unpacker.setCause(ErrDecodeShort)
+		return 0, false
+	}
+	value := unpacker.in[unpacker.pos]
+	unpacker.pos++
+	return value, true
+}
+
+func (unpacker *UnPacker) get16() (uint16, bool) {
+	data, ok := unpacker.next(2)
+	if !ok {
+		return 0, false
+	}
+	if unpacker.mode == ModeSpec {
+		return binary.BigEndian.Uint16(data), true
+	}
+	return binary.LittleEndian.Uint16(data), true
+}
+
+func (unpacker *UnPacker) get32() (uint32, bool) {
+	data, ok := unpacker.next(4)
+	if !ok {
+		return 0, false
+	}
+	if unpacker.mode == ModeSpec {
+		return binary.BigEndian.Uint32(data), true
+	}
+	return binary.LittleEndian.Uint32(data), true
 }
 
-func (unpacker *UnPacker) read(i interface{}) (err error) {
-	if USE_BIGENDIAN {
-		err = binary.Read(unpacker.in_, binary.BigEndian, i)
+func (unpacker *UnPacker) get64() (uint64, bool) {
+	data, ok := unpacker.next(8)
+	if !ok {
+		return 0, false
 	}
-	err = binary.Read(unpacker.in_, binary.LittleEndian, i)
-	return
+	if unpacker.mode == ModeSpec {
+		return binary.BigEndian.Uint64(data), true
+	}
+	return binary.LittleEndian.Uint64(data), true
 }
 
-func (unpacker *UnPacker) readString(slen uint32) (int, string) {
-	data := unpacker.in_.Next(int(slen))
-	if len(data) == int(slen) {
-		return 0, string(data)
+//readLength 读8/16/32位长度
+func (unpacker *UnPacker) readLength(size int) (uint32, bool) {
+	switch size {
+	case 1:
+		length, ok := unpacker.get8()
+		return uint32(length), ok
+	case 2:
+		length, ok := unpacker.get16()
+		return uint32(length), ok
 	}
-	return -1, ""
+	return unpacker.get32()
 }
 
+//readBin 复制一份,Attatch的数据是调用者的
+func (unpacker *UnPacker) readBin(blen uint32) ([]byte, bool) {
+	data, ok := unpacker.next(int(blen))
+	if !ok {
+		return nil, false
+	}
+	value := make([]byte, blen)
+	copy(value, data)
+	return value, true
+}
+
+func (unpacker *UnPacker) readExt(elen uint32) (int, interface{}) {
+	typ, ok := unpacker.get8()
+	if !ok {
+		return -1, nil
+	}
+	data, ok := unpacker.readBin(elen)
+	if !ok {
+		return -1, nil
+	}
+	return 0, &Ext{Type: int8(typ), Data: data}
+}
+
+//unpackInteger 读一个整数,有符号的值按int64存在value里
+func (unpacker *UnPacker) unpackInteger() (value uint64, signed bool, ok bool) {
+	header, ok := unpacker.head()
+	if !ok {
+		return 0, false, false
+	}
+	switch header {
+	case MP_UINT8:
+		v, ok := unpacker.get8()
+		return uint64(v), false, ok
+	case MP_UINT16:
+		v, ok := unpacker.get16()
+		return uint64(v), false, ok
+	case MP_UINT32:
+		v, ok := unpacker.get32()
+		return uint64(v), false, ok
+	case MP_UINT64:
+		v, ok := unpacker.get64()
+		return v, false, ok
+	case MP_INT8:
+		v, ok := unpacker.get8()
+		return uint64(int8(v)), true, ok
+	case MP_INT16:
+		v, ok := unpacker.get16()
+		return uint64(int16(v)), true, ok
+	case MP_INT32:
+		v, ok := unpacker.get32()
+		return uint64(int32(v)), true, ok
+	case MP_INT64:
+		v, ok := unpacker.get64()
+		return v, true, ok
+	}
+	if header <= 127 {
+		return uint64(header), false, true
+	}
+	if (header & uint8(0xE0)) == MP_NEGATIVE_FIXNUM {
+		return uint64(int64(int8(header&uint8(0x1F)) - 32)), true, true
+	}
+	return 0, false, false
+}
+
+//unpackSigned 任何整数格式都可以,值超出[min,max]时失败原因是ErrDecodeOverflow
+func (unpacker *UnPacker) unpackSigned(min int64, max int64, expect string) (int64, bool) {
+	value, signed, ok := unpacker.unpackInteger()
+	if ok {
+		if !signed && value > uint64(max) || signed && (int64(value) < min || int64(value) > max) {
+			unpacker.setCause(ErrDecodeOverflow)
+		} else {
+			return int64(value), true
+		}
+	}
+	unpacker.fail(expect)
+	return 0, false
+}
+
+//unpackUnsigned 任何整数格式都可以,负数或者大于max时失败原因是ErrDecodeOverflow
+func (unpacker *UnPacker) unpackUnsigned(max uint64, expect string) (uint64, bool) {
+	value, signed, ok := unpacker.unpackInteger()
+	if ok {
+		if signed && int64(value) < 0 || value > max {
+			unpacker.setCause(ErrDecodeOverflow)
+		} else {
+			return value, true
+		}
+	}
+	unpacker.fail(expect)
+	return 0, false
+}
+
+//unpackRaw 读str或者bin,返回的切片指向Attatch的数据
+func (unpacker *UnPacker) unpackRaw() ([]byte, bool) {
+	header, ok := unpacker.head()
+	if !ok {
+		return nil, false
+	}
+	var length uint32
+	switch header {
+	case MP_STR8, MP_STR16, MP_STR32:
+		length, ok = unpacker.readSize(headerSize(header, MP_STR8, 1))
+	case MP_BIN8, MP_BIN16, MP_BIN32:
+		length, ok = unpacker.readSize(headerSize(header, MP_BIN8, 1))
+	default:
+		if (header & uint8(0xE0)) != MP_FIXRAW {
+			return nil, false
+		}
+		length = uint32(header - MP_FIXRAW)
+		ok = unpacker.checkSize(length)
+	}
+	if !ok {
+		return nil, false
+	}
+	return unpacker.next(int(length))
+}
+
+//unpackLength 读数组或者map的长度,fix是对应的fix头
+func (unpacker *UnPacker) unpackLength(fix uint8, h16 uint8, h32 uint8) (uint32, bool) {
+	header, ok := unpacker.head()
+	if !ok {
+		return 0, false
+	}
+	per := uint64(1)
+	if fix == MP_FIXMAP {
+		per = 2
+	}
+	switch header {
+	case h16:
+		return unpacker.readCount(2, per)
+	case h32:
+		return unpacker.readCount(4, per)
+	}
+	if (header & uint8(0xF0)) == fix {
+		return uint32(header & uint8(MAX_4BIT)), true
+	}
+	return 0, false
+}
+
+//unpack 解出任意类型的值,给反射和Ext这种不知道类型的地方用
 func (unpacker *UnPacker) unpack() (int, interface{}) {
-	var header uint8
-	if err := unpacker.read(&header); err != nil {
+	header, ok := unpacker.head()
+	if !ok {
 		return -1, nil
 	}
-	var (
-		fVal      float32
-		dVal      float64
-		int8Val   int8
-		int16Val  int16
-		int32Val  int32
-		int64Val  int64
-		uint8Val  uint8
-		uint16Val uint16
-		uint32Val uint32
-		uint64Val uint64
-	)
-	glog.LogConsole(glog.LogInfo, "unpack header", header)
 	switch header {
 	case MP_UINT8:
-		if err := unpacker.read(&uint8Val); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get8(); ok {
+			return 0, v
 		}
-		return 0, uint8Val
+		return -1, 0
 	case MP_UINT16:
-		if err := unpacker.read(&uint16Val); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get16(); ok {
+			return 0, v
 		}
-		return 0, uint16Val
+		return -1, 0
 	case MP_UINT32:
-		if err := unpacker.read(&uint32Val); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get32(); ok {
+			return 0, v
 		}
-		return 0, uint32Val
+		return -1, 0
 	case MP_UINT64:
-		if err := unpacker.read(&uint64Val); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get64(); ok {
+			return 0, v
 		}
-		return 0, uint64Val
+		return -1, 0
 	case MP_INT8:
-		if err := unpacker.read(&int8Val); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get8(); ok {
+			return 0, int8(v)
 		}
-		return 0, int8Val
+		return -1, 0
 	case MP_INT16:
-		if err := unpacker.read(&int16Val); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get16(); ok {
+			return 0, int16(v)
 		}
-		return 0, int16Val
+		return -1, 0
 	case MP_INT32:
-		if err := unpacker.read(&int32Val); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get32(); ok {
+			return 0, int32(v)
 		}
-		return 0, int32Val
+		return -1, 0
 	case MP_INT64:
-		if err := unpacker.read(&int64Val); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get64(); ok {
+			return 0, int64(v)
 		}
-		return 0, int64Val
+		return -1, 0
 	case MP_FLOAT:
-		if err := unpacker.read(&fVal); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get32(); ok {
+			return 0, math.Float32frombits(v)
 		}
-		return 0, fVal
+		return -1, 0
 	case MP_DOUBLE:
-		if err := unpacker.read(&dVal); err != nil {
-			return -1, 0
+		if v, ok := unpacker.get64(); ok {
+			return 0, math.Float64frombits(v)
 		}
-		return 0, dVal
+		return -1, 0
 	case MP_NULL:
 		return 0, nil
 	case MP_FALSE:
 		return 0, false
 	case MP_TRUE:
 		return 0, true
-	case MP_ARRAY16:
-		fallthrough
-	case MP_ARRAY32:
-		fallthrough
-	case MP_MAP16:
-		fallthrough
-	case MP_MAP32:
-		return -2, nil
-	case MP_RAW16:
-		if err := unpacker.read(&uint16Val); err != nil {
-			return -1, nil
+	case MP_ARRAY16, MP_ARRAY32:
+		if length, ok := unpacker.readCount(headerSize(header, MP_ARRAY16, 2), 1); ok {
+			return 0, arrayHeader(length)
 		}
-		return unpacker.readString(uint32(uint16Val))
-	case MP_RAW32:
-		if err := unpacker.read(&uint32Val); err != nil {
-			return -1, nil
+		return -1, nil
+	case MP_MAP16, MP_MAP32:
+		if length, ok := unpacker.readCount(headerSize(header, MP_MAP16, 2), 2); ok {
+			return 0, mapHeader(length)
+		}
+		return -1, nil
+	case MP_STR8, MP_STR16, MP_STR32:
+		if length, ok := unpacker.readSize(headerSize(header, MP_STR8, 1)); ok {
+			if data, ok := unpacker.next(int(length)); ok {
+				return 0, string(data)
+			}
+		}
+		return -1, nil
+	case MP_BIN8, MP_BIN16, MP_BIN32:
+		if length, ok := unpacker.readSize(headerSize(header, MP_BIN8, 1)); ok {
+			if data, ok := unpacker.readBin(length); ok {
+				return 0, data
+			}
+		}
+		return -1, nil
+	case MP_EXT8, MP_EXT16, MP_EXT32:
+		if length, ok := unpacker.readSize(headerSize(header, MP_EXT8, 1)); ok {
+			return unpacker.readExt(length)
 		}
-		return unpacker.readString(uint32(uint32Val))
+		return -1, nil
+	case MP_FIXEXT1, MP_FIXEXT2, MP_FIXEXT4, MP_FIXEXT8, MP_FIXEXT16:
+		return unpacker.readExt(1 << (header - MP_FIXEXT1))
 	}
 
 	if (header & uint8(0xE0)) == MP_FIXRAW {
-		uint8Val = header - MP_FIXRAW
-		return unpacker.readString(uint32(uint8Val))
+		if !unpacker.checkSize(uint32(header - MP_FIXRAW)) {
+			return -1, nil
+		}
+		if data, ok := unpacker.next(int(header - MP_FIXRAW)); ok {
+			return 0, string(data)
+		}
+		return -1, nil
 	}
 
 	if (header & uint8(0xE0)) == MP_NEGATIVE_FIXNUM {
-		int8Val = int8(header&uint8(0x1F)) - 32
-		return 0, int8Val
+		return 0, int8(header&uint8(0x1F)) - 32
 	}
 
 	if (header & uint8(0xF0)) == MP_FIXARRAY {
-		header = MP_FIXARRAY
-		return -2, nil
+		return 0, arrayHeader(header & uint8(MAX_4BIT))
 	}
 
 	if (header & uint8(0xF0)) == MP_FIXMAP {
-		header = MP_FIXMAP
-		return -2, nil
+		return 0, mapHeader(header & uint8(MAX_4BIT))
 	}
 
 	if header <= 127 {
-		uint8Val = header
-		return 0, uint8Val
+		return 0, header
 	}
 
 	return -4, nil
 }
 
+//Attatch 不复制data,解码完之前调用者不能修改,流式解码时丢掉已经缓冲的数据,先解data再接着读
 func (unpacker *UnPacker) Attatch(data []byte) {
-	unpacker.in_.Reset()
-	unpacker.in_.Write(data)
+	unpacker.in = data
+	unpacker.pos = 0
+	unpacker.base = 0
+	unpacker.index = 0
+	unpacker.depth = 0
+	unpacker.cause = nil
+	unpacker.err = nil
+	if unpacker.limits.MaxSize > 0 && len(data) > unpacker.limits.MaxSize {
+		//整个丢掉,之后的解码都失败
+		unpacker.in = nil
+		unpacker.err = &DecodeError{Expect: "data", Err: ErrDecodeLimit}
+	}
 }
 
+//整数可以从任何整数格式解出来,只要值在目标类型的范围内
+//有符号和无符号可以互相转换,超出范围返回-1,Err的原因是ErrDecodeOverflow
 func (unpacker *UnPacker) UnPackUInt64() (r int, value uint64) {
-	if _r, _value := unpacker.unpack(); _r == 0 {
-		switch v := _value.(type) {
-		case uint8:
-			r, value = 0, uint64(v)
-		case uint16:
-			r, value = 0, uint64(v)
-		case uint32:
-			r, value = 0, uint64(v)
-		case uint64:
-			r, value = 0, v
-		default:
-			r, value = -1, 0
-		}
-	} else {
-		r, value = -1, 0
+	if v, ok := unpacker.unpackUnsigned(math.MaxUint64, "uint64"); ok {
+		return 0, v
 	}
-	glog.LogConsole(glog.LogInfo, "UnPackUInt64", value)
-	return
+	return -1, 0
 }
 
 func (unpacker *UnPacker) UnPackUInt32() (r int, value uint32) {
-	if _r, _value := unpacker.unpack(); _r == 0 {
-		switch v := _value.(type) {
-		case uint8:
-			r, value = 0, uint32(v)
-		case uint16:
-			r, value = 0, uint32(v)
-		case uint32:
-			r, value = 0, v
-		default:
-			r, value = -1, 0
-		}
-	} else {
-		r, value = -1, 0
+	if v, ok := unpacker.unpackUnsigned(math.MaxUint32, "uint32"); ok {
+		return 0, uint32(v)
 	}
-	glog.LogConsole(glog.LogInfo, "UnPackUInt32", value)
-	return
+	return -1, 0
 }
 
-func (unpacker *UnPacker) UnPackInt32() (r int, value int32) {
-	if _r, _value := unpacker.unpack(); _r == 0 {
-		switch v := _value.(type) {
-		case uint8:
-			r, value = 0, int32(v)
-		case uint16:
-			r, value = 0, int32(v)
-		case int8:
-			r, value = 0, int32(v)
-		case int16:
-			r, value = 0, int32(v)
-		case int32:
-			r, value = 0, v
-		default:
-			r, value = -1, 0
-		}
-	} else {
-		r, value = -1, 0
+func (unpacker *UnPacker) UnPackUInt16() (r int, value uint16) {
+	if v, ok := unpacker.unpackUnsigned(math.MaxUint16, "uint16"); ok {
+		return 0, uint16(v)
+	}
+	return -1, 0
+}
+
+func (unpacker *UnPacker) UnPackUInt8() (r int, value uint8) {
+	if v, ok := unpacker.unpackUnsigned(math.MaxUint8, "uint8"); ok {
+		return 0, uint8(v)
 	}
-	glog.LogConsole(glog.LogInfo, "UnPackInt32", r, value)
-	return
+	return -1, 0
 }
 
 func (unpacker *UnPacker) UnPackInt64() (r int, value int64) {
-	if _r, _value := unpacker.unpack(); _r == 0 {
-		switch v := _value.(type) {
-		case uint8:
-			r, value = 0, int64(v)
-		case uint16:
-			r, value = 0, int64(v)
-		case uint32:
-			r, value = 0, int64(v)
-		case int8:
-			r, value = 0, int64(v)
-		case int16:
-			r, value = 0, int64(v)
-		case int32:
-			r, value = 0, int64(v)
-		case int64:
-			r, value = 0, v
-		default:
-			r, value = -1, 0
-		}
-	} else {
-		r, value = -1, 0
+	if v, ok := unpacker.unpackSigned(math.MinInt64, math.MaxInt64, "int64"); ok {
+		return 0, v
+	}
+	return -1, 0
+}
+
+func (unpacker *UnPacker) UnPackInt32() (r int, value int32) {
+	if v, ok := unpacker.unpackSigned(math.MinInt32, math.MaxInt32, "int32"); ok {
+		return 0, int32(v)
 	}
-	glog.LogConsole(glog.LogInfo, "UnPackInt64", r, value)
-	return
+	return -1, 0
 }
 
+func (unpacker *UnPacker) UnPackInt16() (r int, value int16) {
+	if v, ok := unpacker.unpackSigned(math.MinInt16, math.MaxInt16, "int16"); ok {
+		return 0, int16(v)
+	}
+	return -1, 0
+}
+
+func (unpacker *UnPacker) UnPackInt8() (r int, value int8) {
+	if v, ok := unpacker.unpackSigned(math.MinInt8, math.MaxInt8, "int8"); ok {
+		return 0, int8(v)
+	}
+	return -1, 0
+}
+
+//UnPackBytes bin和str都可以,以前的版本bytes是按raw打包的
 func (unpacker *UnPacker) UnPackBytes() (r int, value []byte) {
-	if _r, _value := unpacker.unpack(); _r == 0 {
-		switch v := _value.(type) {
-		case string:
-			r, value = _r, []byte(v)
-		default:
-			r, value = -1, nil
-		}
-	} else {
-		r, value = -1, nil
+	data, ok := unpacker.unpackRaw()
+	if !ok {
+		unpacker.fail("bytes")
+		return -1, nil
 	}
-	glog.LogConsole(glog.LogInfo, "UnPackBytes", r, value)
-	return
+	value = make([]byte, len(data))
+	copy(value, data)
+	return 0, value
 }
 
 func (unpacker *UnPacker) UnPackString() (r int, value string) {
-	if _r, _value := unpacker.unpack(); _r == 0 {
-		switch v := _value.(type) {
-		case string:
-			r, value = _r, string(v)
-		default:
-			r, value = -1, ""
-		}
-	} else {
-		r, value = -1, ""
+	data, ok := unpacker.unpackRaw()
+	if !ok {
+		unpacker.fail("string")
+		return -1, ""
 	}
-	glog.LogConsole(glog.LogInfo, "UnPackString", r, value)
-	return
+	return 0, string(data)
 }
 
 func (unpacker *UnPacker) UnPackFloat() (r int, value float32) {
-	if _r, _value := unpacker.unpack(); _r == 0 {
-		switch v := _value.(type) {
-		case float32:
-			r, value = _r, float32(v)
-		default:
-			r, value = -1, 0
+	if header, ok := unpacker.head(); ok && header == MP_FLOAT {
+		if v, ok := unpacker.get32(); ok {
+			return 0, math.Float32frombits(v)
 		}
-	} else {
-		r, value = -1, 0
 	}
-	glog.LogConsole(glog.LogInfo, "UnPackFloat", r, value)
-	return
+	unpacker.fail("float32")
+	return -1, 0
 }
 
 func (unpacker *UnPacker) UnPackDouble() (r int, value float64) {
-	if _r, _value := unpacker.unpack(); _r == 0 {
-		switch v := _value.(type) {
-		case float64:
-			r, value = _r, float64(v)
-		default:
-			r, value = -1, 0
+	if header, ok := unpacker.head(); ok && header == MP_DOUBLE {
+		if v, ok := unpacker.get64(); ok {
+			return 0, math.Float64frombits(v)
 		}
-	} else {
-		r, value = -1, 0
 	}
-	glog.LogConsole(glog.LogInfo, "UnPackDouble", r, value)
-	return
+	unpacker.fail("float64")
+	return -1, 0
 }
 
 func (unpacker *UnPacker) UnPackBool() (r int, value bool) {
-	if _r, _value := unpacker.unpack(); _r == 0 {
-		switch v := _value.(type) {
-		case bool:
-			r, value = _r, bool(v)
-		default:
-			r, value = -1, false
+	if header, ok := unpacker.head(); ok {
+		switch header {
+		case MP_TRUE:
+			return 0, true
+		case MP_FALSE:
+			return 0, false
 		}
-	} else {
-		r, value = -1, false
 	}
-	glog.LogConsole(glog.LogInfo, "UnPackBool", r, value)
-	return
+	unpacker.fail("bool")
+	return -1, false
+}
+
+//UnPackArrayHeader 返回数组元素个数
+func (unpacker *UnPacker) UnPackArrayHeader() (r int, length uint32) {
+	if length, ok := unpacker.unpackLength(MP_FIXARRAY, MP_ARRAY16, MP_ARRAY32); ok {
+		return 0, length
+	}
+	unpacker.fail("array")
+	return -1, 0
+}
+
+//UnPackMapHeader 返回键值对个数
+func (unpacker *UnPacker) UnPackMapHeader() (r int, length uint32) {
+	if length, ok := unpacker.unpackLength(MP_FIXMAP, MP_MAP16, MP_MAP32); ok {
+		return 0, length
+	}
+	unpacker.fail("map")
+	return -1, 0
+}
+
+//UnPackNil 下一个是nil返回0
+func (unpacker *UnPacker) UnPackNil() (r int) {
+	if header, ok := unpacker.head(); ok && header == MP_NULL {
+		return 0
+	}
+	unpacker.fail("nil")
+	return -1
 }
@@ -0,0 +1,170 @@
+package msgpack
+
+import (
+	"strconv"
+	"testing"
+)
+
+var benchLogin = &fuzzLogin{
+	token:     "eyJhbGciOiJIUzI1NiJ9.eyJ1aWQiOjEwMDQyMzE3fQ.sig",
+	msgmd5:    []byte("0123456789abcdef"),
+	scrWidth:  1920,
+	scrHeight: 1080,
+	cannonX:   -360,
+	cannonY:   540,
+}
+
+func benchModes(b *testing.B, f func(b *testing.B, mode int)) {
+	b.Run("legacy", func(b *testing.B) { f(b, ModeLegacy) })
+	b.Run("spec", func(b *testing.B) { f(b, ModeSpec) })
+}
+
+func benchPacked(mode int) []byte {
+	packer := NewPacker()
+	packer.SetMode(mode)
+	packer.PackUInt32(1001)
+	benchLogin.pack(packer)
+	return packer.GetBuffer()
+}
+
+//BenchmarkPackMsg 消息打包,池里取Packer,应该没有分配
+func BenchmarkPackMsg(b *testing.B) {
+	benchModes(b, func(b *testing.B, mode int) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(benchPacked(mode))))
+		for i := 0; i < b.N; i++ {
+			packer := PopPacker()
+			packer.SetMode(mode)
+			packer.PackUInt32(1001)
+			benchLogin.pack(packer)
+			PushPacker(packer)
+		}
+	})
+}
+
+//BenchmarkPackMsgOld 改写之前用binary.Write的打包,对比用
+func BenchmarkPackMsgOld(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchPacked(ModeLegacy))))
+	for i := 0; i < b.N; i++ {
+		packer := &legacyPacker{}
+		packer.packUInt64(1001)
+		packer.packBytes([]byte(benchLogin.token))
+		packer.packBytes(benchLogin.msgmd5)
+		packer.packUInt64(uint64(benchLogin.scrWidth))
+		packer.packUInt64(uint64(benchLogin.scrHeight))
+		packer.packInt64(int64(benchLogin.cannonX))
+		packer.packInt64(int64(benchLogin.cannonY))
+	}
+}
+
+//BenchmarkUnPackMsg 消息解包,只有字符串和bytes的结果本身会分配
+func BenchmarkUnPackMsg(b *testing.B) {
+	benchModes(b, func(b *testing.B, mode int) {
+		data := benchPacked(mode)
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		msg := &fuzzLogin{}
+		for i := 0; i < b.N; i++ {
+			unpacker := PopUnPacker()
+			unpacker.SetMode(mode)
+			unpacker.Attatch(data)
+			if r, _ := unpacker.UnPackUInt32(); r != 0 || msg.unpack(unpacker) != 0 {
+				b.Fatal(unpacker.Err())
+			}
+			PushUnPacker(unpacker)
+		}
+	})
+}
+
+//BenchmarkUnPackInt 数字的解码路径,不能有分配
+func BenchmarkUnPackInt(b *testing.B) {
+	benchModes(b, func(b *testing.B, mode int) {
+		packer := NewPacker()
+		packer.SetMode(mode)
+		for _, v := range []int64{1, -5, 300, -70000, 1 << 40} {
+			packer.PackInt64(v)
+		}
+		data := packer.GetBuffer()
+		unpacker := NewUnPacker()
+		unpacker.SetMode(mode)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			unpacker.Attatch(data)
+			for j := 0; j < 5; j++ {
+				if r, _ := unpacker.UnPackInt64(); r != 0 {
+					b.Fatal(unpacker.Err())
+				}
+			}
+		}
+	})
+}
+
+//BenchmarkUnPackBytes 返回的是拷贝,每次只分配结果本身
+func BenchmarkUnPackBytes(b *testing.B) {
+	for _, size := range []int{16, 4096, 65536} {
+		packer := NewPacker()
+		packer.PackBytes(make([]byte, size))
+		data := packer.GetBuffer()
+		unpacker := NewUnPacker()
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				unpacker.Attatch(data)
+				if r, _ := unpacker.UnPackBytes(); r != 0 {
+					b.Fatal(unpacker.Err())
+				}
+			}
+		})
+	}
+}
+
+//BenchmarkPool 池里取放和每次新建对比
+func BenchmarkPool(b *testing.B) {
+	b.Run("pop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			packer := PopPacker()
+			packer.PackUInt32(1)
+			PushPacker(packer)
+		}
+	})
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			packer := NewPacker()
+			packer.PackUInt32(1)
+		}
+	})
+}
+
+type benchStruct struct {
+	Name  string   `msgpack:"name"`
+	Level int32    `msgpack:"level"`
+	Items []uint32 `msgpack:"items"`
+	Gold  uint64   `msgpack:"gold"`
+}
+
+//BenchmarkMarshal 反射打包和解包
+func BenchmarkMarshal(b *testing.B) {
+	value := &benchStruct{Name: "player", Level: 30, Items: []uint32{1, 2, 3, 1001}, Gold: 1 << 33}
+	data, err := Marshal(value)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			Marshal(value)
+		}
+	})
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		var out benchStruct
+		for i := 0; i < b.N; i++ {
+			if err := Unmarshal(data, &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package msgpack
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	ErrDecodeType     = errors.New("Err DecodeType")
+	ErrDecodeShort    = errors.New("Err DecodeShort")
+	ErrDecodeOverflow = errors.New("Err DecodeOverflow")
+)
+
+//DecodeError 解码失败的位置和原因,UnPacker.Err返回Attatch之后第一次失败的
+type DecodeError struct {
+	Offset int    //出错的值从第几个字节开始
+	Index  int    //第几个值,从0开始,数组和map的头也算一个
+	Expect string //想要的类型
+	Header uint8  //实际读到的类型字节,数据不够时可能没有读到
+	Field  string //反射解码时的字段路径
+	Err    error  //ErrDecodeType,ErrDecodeShort,ErrDecodeOverflow,ErrDecodeLimit或者反射解码的错误
+}
+
+func (err *DecodeError) Error() string {
+	text := "msgpack: " + err.Err.Error() + " expect " + err.Expect +
+		" header 0x" + strconv.FormatUint(uint64(err.Header), 16) +
+		" offset " + strconv.Itoa(err.Offset) + " index " + strconv.Itoa(err.Index)
+	if err.Field != "" {
+		text += " field " + err.Field
+	}
+	return text
+}
+
+func (err *DecodeError) Unwrap() error {
+	return err.Err
+}
+
+//setCause 记下这个值失败的原因,一个值只记第一个
+func (unpacker *UnPacker) setCause(cause error) {
+	if unpacker.cause == nil {
+		unpacker.cause = cause
+	}
+}
+
+//head 读值的类型字节,记下位置给出错时用
+func (unpacker *UnPacker) head() (uint8, bool) {
+	unpacker.mark = unpacker.base + unpacker.pos
+	unpacker.index++
+	unpacker.cause = nil
+	header, ok := unpacker.get8()
+	unpacker.header = header
+	return header, ok
+}
+
+//fail 按最后一次head的位置生成错误,只记第一次的
+func (unpacker *UnPacker) fail(expect string) *DecodeError {
+	cause := unpacker.cause
+	if cause == nil {
+		cause = ErrDecodeType
+	}
+	err := &DecodeError{Offset: unpacker.mark, Index: unpacker.index - 1, Expect: expect, Header: unpacker.header, Err: cause}
+	if unpacker.err == nil {
+		unpacker.err = err
+	}
+	return err
+}
+
+//Err Attatch之后第一次解码失败的原因,没有失败返回nil
+func (unpacker *UnPacker) Err() error {
+	if unpacker.err == nil {
+		return nil
+	}
+	return unpacker.err
+}
+
+//InField r不为0时给第一个错误带上字段名,原样返回r
+//手写和生成的Unpack在字段失败时调用,嵌套的消息从里往外拼成a.b.c
+func (unpacker *UnPacker) InField(r int, name string) int {
+	if r != 0 && unpacker.err != nil {
+		inField(unpacker.err, name)
+	}
+	return r
+}
+
+//inField 出错的字段路径从里往外拼
+func inField(err error, name string) error {
+	if derr, ok := err.(*DecodeError); ok {
+		if derr.Field == "" || derr.Field[0] == '[' {
+			derr.Field = name + derr.Field
+		} else {
+			derr.Field = name + "." + derr.Field
+		}
+	}
+	return err
+}
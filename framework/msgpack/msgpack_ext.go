@@ -0,0 +1,192 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	//ExtTimestamp 规范里的时间戳扩展类型
+	ExtTimestamp = int8(-1)
+)
+
+var (
+	ErrExtType     = errors.New("Err ExtType")
+	ErrExtRegisted = errors.New("Err ExtRegisted")
+)
+
+type arrayHeader uint32
+type mapHeader uint32
+
+//headerSize 同一类型的头是连续的,长度字段依次翻倍
+func headerSize(header uint8, first uint8, firstsize int) int {
+	return firstsize << (header - first)
+}
+
+//Ext 没有注册的扩展类型按原样返回
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+//IExtValue 应用自己的扩展类型,用RegExt注册后UnPackExtValue可以直接解出来
+type IExtValue interface {
+	ExtType() int8
+	MarshalExt() ([]byte, error)
+	UnmarshalExt([]byte) error
+}
+
+var (
+	extlock sync.RWMutex
+	extregs = make(map[int8]func() IExtValue)
+)
+
+//RegExt 注册扩展类型,类型号0到127给应用使用,负数是规范保留的
+func RegExt(typ int8, create func() IExtValue) error {
+	if typ < 0 {
+		return ErrExtType
+	}
+	extlock.Lock()
+	defer extlock.Unlock()
+	if _, ok := extregs[typ]; ok {
+		return ErrExtRegisted
+	}
+	extregs[typ] = create
+	return nil
+}
+
+func findExt(typ int8) func() IExtValue {
+	extlock.RLock()
+	defer extlock.RUnlock()
+	return extregs[typ]
+}
+
+//PackExt 长度是1,2,4,8,16的用fixext
+func (packer *Packer) PackExt(typ int8, data []byte) {
+	length := uint32(len(data))
+	switch length {
+	case 1:
+		packer.put8(MP_FIXEXT1)
+	case 2:
+		packer.put8(MP_FIXEXT2)
+	case 4:
+		packer.put8(MP_FIXEXT4)
+	case 8:
+		packer.put8(MP_FIXEXT8)
+	case 16:
+		packer.put8(MP_FIXEXT16)
+	default:
+		if length <= MAX_8BIT {
+			packer.put8(MP_EXT8).put8(uint8(length))
+		} else if length <= MAX_16BIT {
+			packer.put8(MP_EXT16).put16(uint16(length))
+		} else {
+			packer.put8(MP_EXT32).put32(length)
+		}
+	}
+	packer.put8(uint8(typ))
+	packer.buf = append(packer.buf, data...)
+	packer.spill()
+}
+
+//PackExtValue 打包应用的扩展类型
+func (packer *Packer) PackExtValue(value IExtValue) error {
+	data, err := value.MarshalExt()
+	if err != nil {
+		return err
+	}
+	packer.PackExt(value.ExtType(), data)
+	return nil
+}
+
+//PackTime 时间戳扩展,按规范选32/64/96位格式,数据是大端
+func (packer *Packer) PackTime(value time.Time) {
+	sec := value.Unix()
+	nsec := uint32(value.Nanosecond())
+	if sec>>34 == 0 {
+		data64 := uint64(nsec)<<34 | uint64(sec)
+		if data64&0xffffffff00000000 == 0 {
+			data := make([]byte, 4)
+			binary.BigEndian.PutUint32(data, uint32(data64))
+			packer.PackExt(ExtTimestamp, data)
+			return
+		}
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, data64)
+		packer.PackExt(ExtTimestamp, data)
+		return
+	}
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data, nsec)
+	binary.BigEndian.PutUint64(data[4:], uint64(sec))
+	packer.PackExt(ExtTimestamp, data)
+}
+
+//UnPackExt 返回扩展类型和数据
+func (unpacker *UnPacker) UnPackExt() (r int, typ int8, data []byte) {
+	if _r, _value := unpacker.unpack(); _r == 0 {
+		if v, ok := _value.(*Ext); ok {
+			return 0, v.Type, v.Data
+		}
+	}
+	unpacker.fail("ext")
+	return -1, 0, nil
+}
+
+//UnPackExtValue 时间戳返回time.Time,注册过的返回IExtValue,其他返回*Ext
+func (unpacker *UnPacker) UnPackExtValue() (r int, value interface{}) {
+	r, typ, data := unpacker.UnPackExt()
+	if r != 0 {
+		return
+	}
+	if r, value = decodeExt(typ, data); r != 0 {
+		unpacker.fail("ext")
+	}
+	return
+}
+
+func (unpacker *UnPacker) UnPackTime() (r int, value time.Time) {
+	r, typ, data := unpacker.UnPackExt()
+	if r != 0 {
+		return -1, time.Time{}
+	}
+	if typ == ExtTimestamp {
+		if value, ok := decodeTime(data); ok {
+			return 0, value
+		}
+	}
+	unpacker.fail("time")
+	return -1, time.Time{}
+}
+
+func decodeExt(typ int8, data []byte) (int, interface{}) {
+	if typ == ExtTimestamp {
+		if value, ok := decodeTime(data); ok {
+			return 0, value
+		}
+		return -1, nil
+	}
+	if create := findExt(typ); create != nil {
+		value := create()
+		if value.UnmarshalExt(data) != nil {
+			return -1, nil
+		}
+		return 0, value
+	}
+	return 0, &Ext{Type: typ, Data: data}
+}
+
+func decodeTime(data []byte) (time.Time, bool) {
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0), true
+	case 8:
+		data64 := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(data64&0x3ffffffff), int64(data64>>34)), true
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,188 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrDecodeTrailing = errors.New("Err DecodeTrailing")
+)
+
+//DecodeAny 不需要类型解一个值,数组是[]interface{},map是map[string]interface{}或者map[interface{}]interface{}
+func DecodeAny(data []byte) (interface{}, error) {
+	return DecodeAnyMode(data, ModeLegacy)
+}
+
+//DecodeAnyMode 按指定的编码方式解
+func DecodeAnyMode(data []byte, mode int) (interface{}, error) {
+	unpacker := PopUnPacker()
+	defer PushUnPacker(unpacker)
+	unpacker.SetMode(mode)
+	unpacker.Attatch(data)
+	value, err := unpacker.UnPackAny()
+	if err != nil {
+		return nil, err
+	}
+	if unpacker.pos != len(unpacker.in) {
+		return nil, ErrDecodeTrailing
+	}
+	return value, nil
+}
+
+//DecodeAll 解连续的多个值,消息包是消息号后面跟着各个字段
+func DecodeAll(data []byte) ([]interface{}, error) {
+	return DecodeAllMode(data, ModeLegacy)
+}
+
+//DecodeAllMode 按指定的编码方式解
+func DecodeAllMode(data []byte, mode int) ([]interface{}, error) {
+	unpacker := PopUnPacker()
+	defer PushUnPacker(unpacker)
+	unpacker.SetMode(mode)
+	unpacker.Attatch(data)
+	return unpacker.UnPackAll()
+}
+
+//UnPackAll 解剩下的所有值,出错时返回已经解出来的
+func (unpacker *UnPacker) UnPackAll() ([]interface{}, error) {
+	values := make([]interface{}, 0)
+	for unpacker.pos < len(unpacker.in) {
+		value, err := unpacker.UnPackAny()
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+//UnPackAny 解下一个值,类型和DecodeAny一样
+func (unpacker *UnPacker) UnPackAny() (interface{}, error) {
+	r, item := unpacker.unpack()
+	if r != 0 {
+		return nil, unpacker.fail("any")
+	}
+	return unpacker.anyValue(item)
+}
+
+//ToJSON 把一个msgpack值转成json,bin是base64,不是字符串的键转成字符串
+func ToJSON(data []byte) ([]byte, error) {
+	return ToJSONMode(data, ModeLegacy)
+}
+
+//ToJSONMode 按指定的编码方式转
+func ToJSONMode(data []byte, mode int) ([]byte, error) {
+	value, err := DecodeAnyMode(data, mode)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(JSONValue(value))
+}
+
+//JSONValue 把DecodeAny解出来的值转成json.Marshal能处理的
+func JSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, elem := range v {
+			array[i] = JSONValue(elem)
+		}
+		return array
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[key] = JSONValue(elem)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[fmt.Sprint(key)] = JSONValue(elem)
+		}
+		return m
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case *Ext:
+		return map[string]interface{}{"ext": v.Type, "data": base64.StdEncoding.EncodeToString(v.Data)}
+	}
+	return value
+}
+
+//FromJSON 把json转成msgpack,整数按最小的格式,小数是double,对象的键排好序
+func FromJSON(data []byte) ([]byte, error) {
+	return FromJSONMode(data, ModeLegacy)
+}
+
+//FromJSONMode 按指定的编码方式转
+func FromJSONMode(data []byte, mode int) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, ErrDecodeTrailing
+	}
+	packer := PopPacker()
+	defer PushPacker(packer)
+	packer.ClearBuffer()
+	packer.SetMode(mode)
+	if err := packer.packJSON(value); err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(packer.buf))
+	copy(out, packer.buf)
+	return out, nil
+}
+
+func (packer *Packer) packJSON(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		packer.PackNil()
+	case bool:
+		packer.PackBool(v)
+	case string:
+		packer.PackString(v)
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			packer.packInt64(i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			packer.packUInt64(u)
+		} else if f, err := v.Float64(); err == nil {
+			packer.PackDouble(f)
+		} else {
+			return err
+		}
+	case []interface{}:
+		packer.PackArrayHeader(uint32(len(v)))
+		for _, elem := range v {
+			if err := packer.packJSON(elem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		packer.PackMapHeader(uint32(len(v)))
+		for _, key := range keys {
+			packer.PackString(key)
+			if err := packer.packJSON(v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrMarshalType
+	}
+	return nil
+}
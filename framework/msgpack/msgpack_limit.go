@@ -0,0 +1,97 @@
+package msgpack
+
+import (
+	"errors"
+)
+
+const (
+	defaultMaxDepth = 100
+	streamPrealloc  = 1024
+)
+
+var (
+	ErrDecodeLimit = errors.New("Err DecodeLimit")
+
+	//defaultLimits 只限制层数,太深的嵌套递归会把栈用完
+	defaultLimits = DecodeLimits{MaxDepth: defaultMaxDepth}
+)
+
+//DecodeLimits 解码不可信数据时的上限,0表示不限制,超过时失败原因是ErrDecodeLimit
+type DecodeLimits struct {
+	MaxBytes    int //单个字符串,二进制或者扩展类型的长度
+	MaxElements int //单个数组或者map的元素个数
+	MaxDepth    int //反射解码和DecodeAny时容器嵌套的层数
+	MaxSize     int //Attatch的数据长度,流式解码时是读过的总长度
+}
+
+//SetDefaultLimits 之后创建的和放回池里的UnPacker使用的限制,启动时设置
+func SetDefaultLimits(limits DecodeLimits) {
+	defaultLimits = limits
+}
+
+//DefaultLimits 当前的默认限制
+func DefaultLimits() DecodeLimits {
+	return defaultLimits
+}
+
+//SetLimits 改这个UnPacker的限制,下次Attatch生效
+func (unpacker *UnPacker) SetLimits(limits DecodeLimits) {
+	unpacker.limits = limits
+}
+
+//readSize 读字符串,二进制和扩展类型的长度
+func (unpacker *UnPacker) readSize(size int) (uint32, bool) {
+	length, ok := unpacker.readLength(size)
+	if !ok || !unpacker.checkSize(length) {
+		return 0, false
+	}
+	return length, true
+}
+
+func (unpacker *UnPacker) checkSize(length uint32) bool {
+	if unpacker.limits.MaxBytes > 0 && uint64(length) > uint64(unpacker.limits.MaxBytes) {
+		unpacker.setCause(ErrDecodeLimit)
+		return false
+	}
+	return true
+}
+
+//readCount 读容器的元素个数,per是每个元素几项,map是2
+//每项至少1个字节,剩下的数据不够就是长度被改过,免得按长度预先分配内存
+func (unpacker *UnPacker) readCount(size int, per uint64) (uint32, bool) {
+	length, ok := unpacker.readLength(size)
+	if !ok {
+		return 0, false
+	}
+	if unpacker.limits.MaxElements > 0 && uint64(length) > uint64(unpacker.limits.MaxElements) {
+		unpacker.setCause(ErrDecodeLimit)
+		return 0, false
+	}
+	if unpacker.src == nil && uint64(length)*per > uint64(len(unpacker.in)-unpacker.pos) {
+		unpacker.setCause(ErrDecodeShort)
+		return 0, false
+	}
+	return length, true
+}
+
+//enter 进入一层容器,和leave成对调用
+func (unpacker *UnPacker) enter() error {
+	unpacker.depth++
+	if unpacker.limits.MaxDepth > 0 && unpacker.depth > unpacker.limits.MaxDepth {
+		unpacker.setCause(ErrDecodeLimit)
+		return unpacker.fail("depth")
+	}
+	return nil
+}
+
+func (unpacker *UnPacker) leave() {
+	unpacker.depth--
+}
+
+//prealloc 容器预先分配的大小,流式解码时没法检查剩下的数据,先少分配一点
+func (unpacker *UnPacker) prealloc(length uint32) int {
+	if unpacker.src != nil && length > streamPrealloc {
+		return streamPrealloc
+	}
+	return int(length)
+}
@@ -0,0 +1,426 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+//fuzzLimits 服务器解客户端数据时一般会设的限制
+var fuzzLimits = DecodeLimits{MaxBytes: 1 << 16, MaxElements: 1 << 12, MaxDepth: 32, MaxSize: 1 << 20}
+
+func fuzzMode(spec bool) int {
+	if spec {
+		return ModeSpec
+	}
+	return ModeLegacy
+}
+
+//fuzzSeeds 测试里手写的几个包,框架自己的系统/集群/网关消息打出来的包在testdata/fuzz下面
+func fuzzSeeds(f *testing.F) {
+	for _, mode := range []int{ModeLegacy, ModeSpec} {
+		packer := NewPacker()
+		packer.SetMode(mode)
+		packer.PackUInt32(1001)
+		packer.PackString("token")
+		packer.PackBytes([]byte{1, 2, 3})
+		packer.PackInt32(-300)
+		packer.PackArrayHeader(2)
+		packer.PackMapHeader(1)
+		packer.PackString("k")
+		packer.PackDouble(1.5)
+		packer.PackNil()
+		f.Add(append([]byte(nil), packer.GetBuffer()...), mode == ModeSpec)
+	}
+	f.Add([]byte{MP_ARRAY32, 0xff, 0xff, 0xff, 0xff}, false)
+	f.Add([]byte{MP_BIN32, 0xff, 0xff, 0xff, 0x7f}, true)
+	f.Add(bytes.Repeat([]byte{0x91}, 200), false)
+}
+
+//checkDecodeError 失败时要能取到DecodeError
+func checkDecodeError(t *testing.T, err error) {
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("error without DecodeError: %v", err)
+	}
+	if derr.Offset < 0 {
+		t.Fatalf("bad offset %d", derr.Offset)
+	}
+}
+
+//rawString 旧格式没有bin,[]byte打包后解出来是字符串
+func rawString(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = rawString(elem)
+		}
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = rawString(elem)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, elem := range v {
+			m[rawString(key)] = rawString(elem)
+		}
+		return m
+	}
+	return value
+}
+
+//jsonOf 比较两次解出来的值,NaN之类json表示不了的跳过
+func jsonOf(values []interface{}, mode int) (string, bool) {
+	out := make([]interface{}, len(values))
+	for i, value := range values {
+		if mode == ModeLegacy {
+			value = rawString(value)
+		}
+		out[i] = JSONValue(value)
+	}
+	data, err := json.Marshal(out)
+	return string(data), err == nil
+}
+
+//FuzzUnPackAll 任意数据不能panic,解出来的值重新打包再解要一样
+func FuzzUnPackAll(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte, spec bool) {
+		mode := fuzzMode(spec)
+		unpacker := NewUnPacker()
+		unpacker.SetMode(mode)
+		unpacker.SetLimits(fuzzLimits)
+		unpacker.Attatch(data)
+		values, err := unpacker.UnPackAll()
+		if err != nil {
+			checkDecodeError(t, err)
+			return
+		}
+		packer := NewPacker()
+		packer.SetMode(mode)
+		for _, value := range values {
+			if err := packer.PackValue(value); err != nil {
+				t.Fatalf("repack %T: %v", value, err)
+			}
+		}
+		again := NewUnPacker()
+		again.SetMode(mode)
+		again.Attatch(packer.GetBuffer())
+		values2, err := again.UnPackAll()
+		if err != nil {
+			t.Fatalf("decode repacked: %v", err)
+		}
+		first, ok1 := jsonOf(values, mode)
+		second, ok2 := jsonOf(values2, mode)
+		if ok1 && ok2 && first != second {
+			t.Fatalf("round trip changed values\n%s\n%s", first, second)
+		}
+	})
+}
+
+//FuzzDecoder 流式解码要能结束,不能panic,解出来的个数和一次解完的一样
+func FuzzDecoder(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte, spec bool) {
+		mode := fuzzMode(spec)
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.SetMode(mode)
+		dec.SetLimits(fuzzLimits)
+		dec.SetMaxSize(1 << 16)
+		count := 0
+		for {
+			var value interface{}
+			err := dec.Decode(&value)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return
+			}
+			count++
+			if count > len(data) {
+				t.Fatalf("decoded %d values from %d bytes", count, len(data))
+			}
+		}
+		unpacker := NewUnPacker()
+		unpacker.SetMode(mode)
+		unpacker.SetLimits(fuzzLimits)
+		unpacker.Attatch(data)
+		values, err := unpacker.UnPackAll()
+		if err == nil && len(values) != count {
+			t.Fatalf("stream decoded %d values, buffer decoded %d", count, len(values))
+		}
+	})
+}
+
+//fuzzLogin 和gen-proto/test.proto里的C2G_Login一样的字段
+type fuzzLogin struct {
+	token     string
+	msgmd5    []byte
+	scrWidth  uint32
+	scrHeight uint32
+	cannonX   int32
+	cannonY   int32
+}
+
+func (msg *fuzzLogin) pack(packer *Packer) {
+	packer.PackString(msg.token)
+	packer.PackBytes(msg.msgmd5)
+	packer.PackUInt32(msg.scrWidth)
+	packer.PackUInt32(msg.scrHeight)
+	packer.PackInt32(msg.cannonX)
+	packer.PackInt32(msg.cannonY)
+}
+
+func (msg *fuzzLogin) unpack(unpacker *UnPacker) (r int) {
+	if r, msg.token = unpacker.UnPackString(); r != 0 {
+		return
+	}
+	if r, msg.msgmd5 = unpacker.UnPackBytes(); r != 0 {
+		return
+	}
+	if r, msg.scrWidth = unpacker.UnPackUInt32(); r != 0 {
+		return
+	}
+	if r, msg.scrHeight = unpacker.UnPackUInt32(); r != 0 {
+		return
+	}
+	if r, msg.cannonX = unpacker.UnPackInt32(); r != 0 {
+		return
+	}
+	r, msg.cannonY = unpacker.UnPackInt32()
+	return
+}
+
+//fuzzTyped 覆盖其他类型的解包函数,数组和map按头里的个数一个一个解
+type fuzzTyped struct {
+	f32   float32
+	f64   float64
+	flag  bool
+	i8    int8
+	i16   int16
+	i64   int64
+	u8    uint8
+	u16   uint16
+	u64   uint64
+	items []uint32
+	keys  []string
+	vals  []int64
+	exttp int8
+	ext   []byte
+	when  time.Time
+}
+
+func (msg *fuzzTyped) pack(packer *Packer) {
+	packer.PackFloat(msg.f32)
+	packer.PackDouble(msg.f64)
+	packer.PackBool(msg.flag)
+	packer.PackInt8(msg.i8)
+	packer.PackInt16(msg.i16)
+	packer.PackInt64(msg.i64)
+	packer.PackUInt8(msg.u8)
+	packer.PackUInt16(msg.u16)
+	packer.PackUInt64(msg.u64)
+	packer.PackArrayHeader(uint32(len(msg.items)))
+	for _, item := range msg.items {
+		packer.PackUInt32(item)
+	}
+	packer.PackMapHeader(uint32(len(msg.keys)))
+	for i, key := range msg.keys {
+		packer.PackString(key)
+		packer.PackInt64(msg.vals[i])
+	}
+	packer.PackNil()
+	packer.PackExt(msg.exttp, msg.ext)
+	packer.PackTime(msg.when)
+}
+
+func (msg *fuzzTyped) unpack(unpacker *UnPacker) (r int) {
+	if r, msg.f32 = unpacker.UnPackFloat(); r != 0 {
+		return
+	}
+	if r, msg.f64 = unpacker.UnPackDouble(); r != 0 {
+		return
+	}
+	if r, msg.flag = unpacker.UnPackBool(); r != 0 {
+		return
+	}
+	if r, msg.i8 = unpacker.UnPackInt8(); r != 0 {
+		return
+	}
+	if r, msg.i16 = unpacker.UnPackInt16(); r != 0 {
+		return
+	}
+	if r, msg.i64 = unpacker.UnPackInt64(); r != 0 {
+		return
+	}
+	if r, msg.u8 = unpacker.UnPackUInt8(); r != 0 {
+		return
+	}
+	if r, msg.u16 = unpacker.UnPackUInt16(); r != 0 {
+		return
+	}
+	if r, msg.u64 = unpacker.UnPackUInt64(); r != 0 {
+		return
+	}
+	var count uint32
+	if r, count = unpacker.UnPackArrayHeader(); r != 0 {
+		return
+	}
+	for i := uint32(0); i < count; i++ {
+		var item uint32
+		if r, item = unpacker.UnPackUInt32(); r != 0 {
+			return
+		}
+		msg.items = append(msg.items, item)
+	}
+	if r, count = unpacker.UnPackMapHeader(); r != 0 {
+		return
+	}
+	for i := uint32(0); i < count; i++ {
+		var (
+			key string
+			val int64
+		)
+		if r, key = unpacker.UnPackString(); r != 0 {
+			return
+		}
+		if r, val = unpacker.UnPackInt64(); r != 0 {
+			return
+		}
+		msg.keys = append(msg.keys, key)
+		msg.vals = append(msg.vals, val)
+	}
+	if r = unpacker.UnPackNil(); r != 0 {
+		return
+	}
+	if r, msg.exttp, msg.ext = unpacker.UnPackExt(); r != 0 {
+		return
+	}
+	r, msg.when = unpacker.UnPackTime()
+	return
+}
+
+func (msg *fuzzTyped) equal(other *fuzzTyped) bool {
+	//NaN不等于自己,浮点数按位比较
+	if math.Float32bits(msg.f32) != math.Float32bits(other.f32) || math.Float64bits(msg.f64) != math.Float64bits(other.f64) {
+		return false
+	}
+	if msg.flag != other.flag || msg.i8 != other.i8 || msg.i16 != other.i16 || msg.i64 != other.i64 ||
+		msg.u8 != other.u8 || msg.u16 != other.u16 || msg.u64 != other.u64 {
+		return false
+	}
+	if len(msg.items) != len(other.items) || len(msg.keys) != len(other.keys) {
+		return false
+	}
+	for i := range msg.items {
+		if msg.items[i] != other.items[i] {
+			return false
+		}
+	}
+	for i := range msg.keys {
+		if msg.keys[i] != other.keys[i] || msg.vals[i] != other.vals[i] {
+			return false
+		}
+	}
+	return msg.exttp == other.exttp && bytes.Equal(msg.ext, other.ext) && msg.when.Equal(other.when)
+}
+
+//fuzzTypedSeeds 每个字段都有的包
+func fuzzTypedSeeds(f *testing.F) {
+	msg := &fuzzTyped{f32: -1.25, f64: math.Inf(1), flag: true, i8: -128, i16: 300, i64: -1 << 40, u8: 255, u16: 65535, u64: 1 << 63,
+		items: []uint32{1, 1 << 20}, keys: []string{"gold", "exp"}, vals: []int64{-1, 1 << 33}, exttp: 7, ext: []byte{1, 2, 3}, when: time.Unix(1700000000, 5)}
+	for _, mode := range []int{ModeLegacy, ModeSpec} {
+		packer := NewPacker()
+		packer.SetMode(mode)
+		packer.PackUInt32(1002)
+		msg.pack(packer)
+		f.Add(append([]byte(nil), packer.GetBuffer()...), mode == ModeSpec)
+	}
+}
+
+//FuzzUnPackMsg 按生成代码的方式一个字段一个字段解,解成功的重新打包再解要一样
+func FuzzUnPackMsg(f *testing.F) {
+	fuzzSeeds(f)
+	fuzzTypedSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte, spec bool) {
+		mode := fuzzMode(spec)
+		//同一份数据两种消息都解一遍
+		decode := func(data []byte, unpack func(*UnPacker) int) (uint32, bool) {
+			unpacker := NewUnPacker()
+			unpacker.SetMode(mode)
+			unpacker.SetLimits(fuzzLimits)
+			unpacker.Attatch(data)
+			r, id := unpacker.UnPackUInt32()
+			if r == 0 {
+				r = unpack(unpacker)
+			}
+			if r != 0 {
+				checkDecodeError(t, unpacker.Err())
+				return 0, false
+			}
+			return id, true
+		}
+		repack := func(id uint32, pack func(*Packer)) []byte {
+			packer := NewPacker()
+			packer.SetMode(mode)
+			packer.PackUInt32(id)
+			pack(packer)
+			return packer.GetBuffer()
+		}
+
+		login := &fuzzLogin{}
+		if id, ok := decode(data, login.unpack); ok {
+			login2 := &fuzzLogin{}
+			if id2, ok := decode(repack(id, login.pack), login2.unpack); !ok || id2 != id {
+				t.Fatal("decode repacked login")
+			}
+			if login.token != login2.token || !bytes.Equal(login.msgmd5, login2.msgmd5) || login.scrWidth != login2.scrWidth ||
+				login.scrHeight != login2.scrHeight || login.cannonX != login2.cannonX || login.cannonY != login2.cannonY {
+				t.Fatalf("round trip changed values %+v %+v", login, login2)
+			}
+		}
+
+		typed := &fuzzTyped{}
+		if id, ok := decode(data, typed.unpack); ok {
+			typed2 := &fuzzTyped{}
+			if id2, ok := decode(repack(id, typed.pack), typed2.unpack); !ok || id2 != id {
+				t.Fatal("decode repacked typed")
+			}
+			if !typed.equal(typed2) {
+				t.Fatalf("round trip changed values %+v %+v", typed, typed2)
+			}
+		}
+	})
+}
+
+type fuzzStruct struct {
+	Name  string            `msgpack:"name"`
+	Level int16             `msgpack:"level"`
+	Items []uint32          `msgpack:"items"`
+	Attrs map[string]string `msgpack:"attrs"`
+	Next  *fuzzStruct       `msgpack:"next"`
+}
+
+//FuzzUnmarshal 反射解码不能panic
+func FuzzUnmarshal(f *testing.F) {
+	fuzzSeeds(f)
+	data, _ := Marshal(&fuzzStruct{Name: "a", Level: -3, Items: []uint32{1, 300}, Attrs: map[string]string{"x": "y"}, Next: &fuzzStruct{Name: "b"}})
+	f.Add(data, false)
+	f.Fuzz(func(t *testing.T, data []byte, spec bool) {
+		unpacker := NewUnPacker()
+		unpacker.SetMode(fuzzMode(spec))
+		unpacker.SetLimits(fuzzLimits)
+		unpacker.Attatch(data)
+		var v fuzzStruct
+		if err := unpacker.UnPackValue(&v); err != nil {
+			checkDecodeError(t, err)
+		}
+	})
+}
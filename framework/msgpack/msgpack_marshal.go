@@ -0,0 +1,750 @@
+package msgpack
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMarshalType   = errors.New("Err MarshalType")
+	ErrUnmarshalPtr  = errors.New("Err UnmarshalPtr")
+	ErrUnmarshalType = errors.New("Err UnmarshalType")
+	ErrUnmarshalData = errors.New("Err UnmarshalData")
+	ErrMarshalCycle  = errors.New("Err MarshalCycle")
+
+	typeTime     = reflect.TypeOf(time.Time{})
+	typeExtValue = reflect.TypeOf((*IExtValue)(nil)).Elem()
+	typeExt      = reflect.TypeOf(Ext{})
+
+	//structPlans reflect.Type到*structPlan,每个类型只分析一次
+	structPlans sync.Map
+)
+
+//marshalCycleLevel 正常的数据不会套这么多层,超过以后才记地址,不影响平时的速度
+const marshalCycleLevel = 1000
+
+//packRef slice的地址一样长度不一样是不同的值
+type packRef struct {
+	ptr uintptr
+	len int
+}
+
+//fieldPlan 结构体的一个字段,tag是数字的用整数做键
+type fieldPlan struct {
+	index     []int
+	name      string
+	num       uint64
+	numeric   bool
+	omitempty bool
+}
+
+type structPlan struct {
+	fields []*fieldPlan
+	byname map[string]*fieldPlan
+	bynum  map[uint64]*fieldPlan
+}
+
+func getStructPlan(t reflect.Type) *structPlan {
+	if plan, ok := structPlans.Load(t); ok {
+		return plan.(*structPlan)
+	}
+	plan := &structPlan{byname: make(map[string]*fieldPlan), bynum: make(map[uint64]*fieldPlan)}
+	plan.build(t, nil)
+	actual, _ := structPlans.LoadOrStore(t, plan)
+	return actual.(*structPlan)
+}
+
+//build 没有tag的匿名结构体字段展开到外层
+func (plan *structPlan) build(t reflect.Type, parent []int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("msgpack")
+		if tag == "-" {
+			continue
+		}
+		index := append(append([]int{}, parent...), i)
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			plan.build(field.Type, index)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		fp := &fieldPlan{index: index, name: field.Name}
+		if tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				fp.name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					fp.omitempty = true
+				}
+			}
+		}
+		if num, err := strconv.ParseUint(fp.name, 10, 64); err == nil {
+			fp.num = num
+			fp.numeric = true
+			plan.bynum[num] = fp
+		} else {
+			plan.byname[fp.name] = fp
+		}
+		plan.fields = append(plan.fields, fp)
+	}
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == typeTime {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+//Marshal 把Go的值打包,结构体打成map,键是msgpack tag或者字段名
+func Marshal(v interface{}) ([]byte, error) {
+	packer := PopPacker()
+	defer PushPacker(packer)
+	packer.ClearBuffer()
+	if err := packer.PackValue(v); err != nil {
+		return nil, err
+	}
+	data := packer.GetBuffer()
+	out := make([]byte, len(data))
+	copy(out, data)
+	return out, nil
+}
+
+//Unmarshal 解到v,v必须是非nil指针
+func Unmarshal(data []byte, v interface{}) error {
+	unpacker := PopUnPacker()
+	defer PushUnPacker(unpacker)
+	unpacker.Attatch(data)
+	return unpacker.UnPackValue(v)
+}
+
+//PackValue 可以在IMsg.Pack里打包任意值
+func (packer *Packer) PackValue(v interface{}) error {
+	if v == nil {
+		packer.PackNil()
+		return nil
+	}
+	return packer.packValue(reflect.ValueOf(v))
+}
+
+func (packer *Packer) packValue(v reflect.Value) error {
+	switch {
+	case v.Kind() == reflect.Interface:
+	case v.Kind() == reflect.Ptr && v.IsNil():
+	case v.Type().Implements(typeExtValue):
+		return packer.PackExtValue(v.Interface().(IExtValue))
+	case v.CanAddr() && reflect.PtrTo(v.Type()).Implements(typeExtValue):
+		return packer.PackExtValue(v.Addr().Interface().(IExtValue))
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		packer.PackBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		packer.packInt64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		packer.packUInt64(v.Uint())
+	case reflect.Float32:
+		packer.PackFloat(float32(v.Float()))
+	case reflect.Float64:
+		packer.PackDouble(v.Float())
+	case reflect.String:
+		packer.packStr(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			packer.PackNil()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			packer.packBin(v.Bytes())
+			return nil
+		}
+		return packer.packRef(v, v.Len())
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(data), v)
+			packer.packBin(data)
+			return nil
+		}
+		return packer.packArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			packer.PackNil()
+			return nil
+		}
+		return packer.packRef(v, 0)
+	case reflect.Struct:
+		if v.Type() == typeTime {
+			packer.PackTime(v.Interface().(time.Time))
+			return nil
+		}
+		if v.Type() == typeExt {
+			//没注册的扩展类型解出来是*Ext,原样打回去
+			ext := v.Interface().(Ext)
+			packer.PackExt(ext.Type, ext.Data)
+			return nil
+		}
+		return packer.packStruct(v)
+	case reflect.Ptr:
+		if v.IsNil() {
+			packer.PackNil()
+			return nil
+		}
+		return packer.packRef(v, 0)
+	case reflect.Interface:
+		if v.IsNil() {
+			packer.PackNil()
+			return nil
+		}
+		return packer.packValue(v.Elem())
+	default:
+		return ErrMarshalType
+	}
+	return nil
+}
+
+//packRefValue slice,map或者指针,调用方已经判断过nil
+func (packer *Packer) packRefValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		return packer.packArray(v)
+	case reflect.Map:
+		return packer.packMap(v)
+	}
+	return packer.packValue(v.Elem())
+}
+
+//packRef 套得很深时记下走过的地址,又走到同一个地址就是有环,不然会一直递归到栈溢出
+func (packer *Packer) packRef(v reflect.Value, length int) error {
+	packer.reflevel++
+	if packer.reflevel <= marshalCycleLevel {
+		err := packer.packRefValue(v)
+		packer.reflevel--
+		return err
+	}
+	ref := packRef{ptr: v.Pointer(), len: length}
+	if packer.refseen[ref] {
+		packer.reflevel--
+		return ErrMarshalCycle
+	}
+	if packer.refseen == nil {
+		packer.refseen = make(map[packRef]bool)
+	}
+	packer.refseen[ref] = true
+	err := packer.packRefValue(v)
+	delete(packer.refseen, ref)
+	packer.reflevel--
+	return err
+}
+
+func (packer *Packer) packArray(v reflect.Value) error {
+	packer.PackArrayHeader(uint32(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		if err := packer.packValue(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//packMap 字符串和整数键排好序,同样的内容打出来一样,方便比较快照
+func (packer *Packer) packMap(v reflect.Value) error {
+	packer.PackMapHeader(uint32(v.Len()))
+	var keys []reflect.Value
+	switch v.Type().Key().Kind() {
+	case reflect.String:
+		keys = v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		keys = v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Int() < keys[j].Int() })
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		keys = v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Uint() < keys[j].Uint() })
+	default:
+		//不排序的直接遍历,NaN做键时MapIndex找不到值
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := packer.packValue(iter.Key()); err != nil {
+				return err
+			}
+			if err := packer.packValue(iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, key := range keys {
+		if err := packer.packValue(key); err != nil {
+			return err
+		}
+		if err := packer.packValue(v.MapIndex(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (packer *Packer) packStruct(v reflect.Value) error {
+	plan := getStructPlan(v.Type())
+	fields := make([]reflect.Value, 0, len(plan.fields))
+	plans := make([]*fieldPlan, 0, len(plan.fields))
+	for _, fp := range plan.fields {
+		field := v.FieldByIndex(fp.index)
+		if fp.omitempty && isEmptyValue(field) {
+			continue
+		}
+		fields = append(fields, field)
+		plans = append(plans, fp)
+	}
+	packer.PackMapHeader(uint32(len(fields)))
+	for i, field := range fields {
+		if plans[i].numeric {
+			packer.packUInt64(plans[i].num)
+		} else {
+			packer.packStr(plans[i].name)
+		}
+		if err := packer.packValue(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//UnPackValue 可以在IMsg.Unpack里解任意值,v必须是非nil指针
+func (unpacker *UnPacker) UnPackValue(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrUnmarshalPtr
+	}
+	return unpacker.unpackValue(rv.Elem())
+}
+
+func (unpacker *UnPacker) unpackValue(v reflect.Value) error {
+	r, item := unpacker.unpack()
+	if r != 0 {
+		return unpacker.fail(v.Type().String())
+	}
+	//容器的元素出错时已经是DecodeError了,这里只包装这一项自己的错误
+	mark, index, header := unpacker.mark, unpacker.index, unpacker.header
+	err := unpacker.setValue(v, item)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*DecodeError); ok {
+		return err
+	}
+	derr := &DecodeError{Offset: mark, Index: index - 1, Expect: v.Type().String(), Header: header, Err: err}
+	if unpacker.err == nil {
+		unpacker.err = derr
+	}
+	return derr
+}
+
+//setValue item是unpack读出来的一项,容器的话接着读里面的元素
+func (unpacker *UnPacker) setValue(v reflect.Value, item interface{}) error {
+	if item == nil {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unpacker.setValue(v.Elem(), item)
+	}
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		value, err := unpacker.anyValue(item)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+	switch value := item.(type) {
+	case arrayHeader:
+		return unpacker.setArray(v, uint32(value))
+	case mapHeader:
+		return unpacker.setMap(v, uint32(value))
+	case *Ext:
+		return setExt(v, value)
+	case bool:
+		if v.Kind() != reflect.Bool {
+			return ErrUnmarshalType
+		}
+		v.SetBool(value)
+	case string:
+		return setBytes(v, value, nil)
+	case []byte:
+		return setBytes(v, "", value)
+	case float32:
+		return setFloat(v, float64(value))
+	case float64:
+		return setFloat(v, value)
+	default:
+		return setInteger(v, item)
+	}
+	return nil
+}
+
+func setBytes(v reflect.Value, str string, bin []byte) error {
+	if bin == nil {
+		bin = []byte(str)
+	} else {
+		str = string(bin)
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(str)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(bin)
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		reflect.Copy(v, reflect.ValueOf(bin))
+	default:
+		return ErrUnmarshalType
+	}
+	return nil
+}
+
+func setFloat(v reflect.Value, value float64) error {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(value)
+		return nil
+	}
+	return ErrUnmarshalType
+}
+
+//setInteger 整数可以互相转换,放不下的是ErrDecodeOverflow
+func setInteger(v reflect.Value, item interface{}) error {
+	var (
+		ivalue   int64
+		uvalue   uint64
+		unsigned bool
+	)
+	switch value := item.(type) {
+	case int8:
+		ivalue = int64(value)
+	case int16:
+		ivalue = int64(value)
+	case int32:
+		ivalue = int64(value)
+	case int64:
+		ivalue = value
+	case uint8:
+		uvalue, unsigned = uint64(value), true
+	case uint16:
+		uvalue, unsigned = uint64(value), true
+	case uint32:
+		uvalue, unsigned = uint64(value), true
+	case uint64:
+		uvalue, unsigned = value, true
+	default:
+		return ErrUnmarshalType
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if unsigned {
+			if uvalue > math.MaxInt64 {
+				return ErrDecodeOverflow
+			}
+			ivalue = int64(uvalue)
+		}
+		if v.OverflowInt(ivalue) {
+			return ErrDecodeOverflow
+		}
+		v.SetInt(ivalue)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !unsigned {
+			if ivalue < 0 {
+				return ErrDecodeOverflow
+			}
+			uvalue = uint64(ivalue)
+		}
+		if v.OverflowUint(uvalue) {
+			return ErrDecodeOverflow
+		}
+		v.SetUint(uvalue)
+	case reflect.Float32, reflect.Float64:
+		if unsigned {
+			v.SetFloat(float64(uvalue))
+		} else {
+			v.SetFloat(float64(ivalue))
+		}
+	default:
+		return ErrUnmarshalType
+	}
+	return nil
+}
+
+func setExt(v reflect.Value, ext *Ext) error {
+	r, value := decodeExt(ext.Type, ext.Data)
+	if r != 0 {
+		return ErrUnmarshalData
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Type().AssignableTo(v.Type()) {
+		v.Set(rv)
+		return nil
+	}
+	//注册的类型create返回指针,字段是值的时候取出来
+	if rv.Kind() == reflect.Ptr && rv.Elem().Type().AssignableTo(v.Type()) {
+		v.Set(rv.Elem())
+		return nil
+	}
+	return ErrUnmarshalType
+}
+
+func (unpacker *UnPacker) setArray(v reflect.Value, length uint32) error {
+	if err := unpacker.enter(); err != nil {
+		return err
+	}
+	defer unpacker.leave()
+	switch v.Kind() {
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), 0, unpacker.prealloc(length))
+		zero := reflect.Zero(v.Type().Elem())
+		for i := 0; i < int(length); i++ {
+			slice = reflect.Append(slice, zero)
+			if err := unpacker.unpackValue(slice.Index(i)); err != nil {
+				return inField(err, "["+strconv.Itoa(i)+"]")
+			}
+		}
+		v.Set(slice)
+	case reflect.Array:
+		for i := 0; i < int(length); i++ {
+			if i >= v.Len() {
+				if err := unpacker.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := unpacker.unpackValue(v.Index(i)); err != nil {
+				return inField(err, "["+strconv.Itoa(i)+"]")
+			}
+		}
+	default:
+		return ErrUnmarshalType
+	}
+	return nil
+}
+
+func (unpacker *UnPacker) setMap(v reflect.Value, length uint32) error {
+	if err := unpacker.enter(); err != nil {
+		return err
+	}
+	defer unpacker.leave()
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for i := uint32(0); i < length; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := unpacker.unpackValue(key); err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := unpacker.unpackValue(elem); err != nil {
+				return inField(err, "["+fmt.Sprint(key.Interface())+"]")
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		plan := getStructPlan(v.Type())
+		for i := uint32(0); i < length; i++ {
+			r, key := unpacker.unpack()
+			if r != 0 {
+				return unpacker.fail("field")
+			}
+			fp := plan.find(key)
+			if fp == nil {
+				//新版本加的字段,旧代码跳过
+				if err := unpacker.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := unpacker.unpackValue(fieldByIndex(v, fp.index)); err != nil {
+				return inField(err, fp.name)
+			}
+		}
+	default:
+		return ErrUnmarshalType
+	}
+	return nil
+}
+
+func (plan *structPlan) find(key interface{}) *fieldPlan {
+	switch k := key.(type) {
+	case string:
+		return plan.byname[k]
+	case uint8:
+		return plan.bynum[uint64(k)]
+	case uint16:
+		return plan.bynum[uint64(k)]
+	case uint32:
+		return plan.bynum[uint64(k)]
+	case uint64:
+		return plan.bynum[k]
+	case int8:
+		return plan.findInt(int64(k))
+	case int16:
+		return plan.findInt(int64(k))
+	case int32:
+		return plan.findInt(int64(k))
+	case int64:
+		return plan.findInt(k)
+	}
+	return nil
+}
+
+//findInt 别的语言的库可能把正数键打成有符号的格式
+func (plan *structPlan) findInt(key int64) *fieldPlan {
+	if key < 0 {
+		return nil
+	}
+	return plan.bynum[uint64(key)]
+}
+
+//fieldByIndex 匿名结构体字段都是值,不会碰到nil指针
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		v = v.Field(i)
+	}
+	return v
+}
+
+//skip 跳过一项,容器连里面的元素一起跳过
+func (unpacker *UnPacker) skip() error {
+	r, item := unpacker.unpack()
+	if r != 0 {
+		return unpacker.fail("any")
+	}
+	count := uint64(0)
+	switch value := item.(type) {
+	case arrayHeader:
+		count = uint64(value)
+	case mapHeader:
+		count = uint64(value) * 2
+	}
+	if count > 0 {
+		if err := unpacker.enter(); err != nil {
+			return err
+		}
+		defer unpacker.leave()
+	}
+	for i := uint64(0); i < count; i++ {
+		if err := unpacker.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//anyValue 解到interface{},数组是[]interface{},键都是字符串的map是map[string]interface{}
+func (unpacker *UnPacker) anyValue(item interface{}) (interface{}, error) {
+	switch item.(type) {
+	case arrayHeader, mapHeader:
+		if err := unpacker.enter(); err != nil {
+			return nil, err
+		}
+		defer unpacker.leave()
+	}
+	switch value := item.(type) {
+	case arrayHeader:
+		array := make([]interface{}, 0, unpacker.prealloc(uint32(value)))
+		for i := uint32(0); i < uint32(value); i++ {
+			r, elem := unpacker.unpack()
+			if r != 0 {
+				return nil, unpacker.fail("any")
+			}
+			v, err := unpacker.anyValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, v)
+		}
+		return array, nil
+	case mapHeader:
+		keys := make([]interface{}, 0, unpacker.prealloc(uint32(value)))
+		values := make([]interface{}, 0, unpacker.prealloc(uint32(value)))
+		strkey := true
+		for i := uint32(0); i < uint32(value); i++ {
+			for j := 0; j < 2; j++ {
+				r, elem := unpacker.unpack()
+				if r != 0 {
+					return nil, unpacker.fail("any")
+				}
+				v, err := unpacker.anyValue(elem)
+				if err != nil {
+					return nil, err
+				}
+				if j == 0 {
+					if _, ok := v.(string); !ok {
+						strkey = false
+					}
+					keys = append(keys, v)
+				} else {
+					values = append(values, v)
+				}
+			}
+		}
+		if strkey {
+			m := make(map[string]interface{}, len(keys))
+			for i, key := range keys {
+				m[key.(string)] = values[i]
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{}, len(keys))
+		for i, key := range keys {
+			if key == nil || !reflect.TypeOf(key).Comparable() {
+				//数组和map不能当键
+				unpacker.setCause(ErrUnmarshalType)
+				return nil, unpacker.fail("map key")
+			}
+			m[key] = values[i]
+		}
+		return m, nil
+	case *Ext:
+		r, v := decodeExt(value.Type, value.Data)
+		if r != 0 {
+			unpacker.setCause(ErrUnmarshalData)
+			return nil, unpacker.fail("ext")
+		}
+		return v, nil
+	}
+	return item, nil
+}
@@ -1,56 +1,71 @@
 package msgpack
 
 import (
-	"bytes"
+	"io"
+	"sync/atomic"
 )
 
 var (
-	pool *msgPackUnPackPool
+	pool = newMsgPackUnPackPool()
 )
 
 func NewPacker() *Packer {
-	return &Packer{out_: bytes.NewBuffer(make([]byte, PackbuffSize))}
+	return &Packer{buf: make([]byte, 0, PackbuffSize)}
 }
 
 func NewUnPacker() *UnPacker {
-	return &UnPacker{in_: bytes.NewBuffer(make([]byte, PackbuffSize))}
+	return &UnPacker{limits: defaultLimits}
 }
 
+func NewEncoder(wr io.Writer) *Encoder {
+	enc := &Encoder{wr: wr, flushsize: encodeFlushSize}
+	enc.buf = make([]byte, 0, encodeFlushSize)
+	enc.enc = enc
+	return enc
+}
+
+func NewDecoder(rd io.Reader) *Decoder {
+	dec := &Decoder{}
+	dec.limits = defaultLimits
+	dec.src = &decodeSource{rd: rd}
+	return dec
+}
+
+//InitMsgPackUnPackPool 池子不需要初始化了,留着给旧代码调用
 func InitMsgPackUnPackPool(poolnum int) {
-	if pool == nil {
-		pool = &msgPackUnPackPool{}
-		pool.init(poolnum)
-	}
 }
 
+//DestoryMsgPackUnPackPool 池子里的对象由GC回收,留着给旧代码调用
 func DestoryMsgPackUnPackPool() {
-	if pool != nil {
-		pool.destory()
-	}
+}
+
+//SetPoolMaxBuffer 缓冲区容量超过size的Packer不放回池子,默认256K
+func SetPoolMaxBuffer(size int) {
+	atomic.StoreInt64(&pool.maxsize, int64(size))
+}
+
+//GetPoolStats 池子的命中统计
+func GetPoolStats() PoolStats {
+	return pool.getStats()
 }
 
 func PopPacker() *Packer {
-	if pool != nil {
-		return pool.popPacker()
-	}
-	return NewPacker()
+	return pool.popPacker(PackbuffSize)
+}
+
+//PopPackerSize 预计要打包size字节时用,取到的缓冲区至少这么大
+func PopPackerSize(size int) *Packer {
+	return pool.popPacker(size)
 }
 
 func PushPacker(packer *Packer) {
-	if pool != nil {
-		pool.pushPacker(packer)
-	}
+	pool.pushPacker(packer)
 }
 
 func PopUnPacker() *UnPacker {
-	if pool != nil {
-		return pool.popUnPacker()
-	}
-	return NewUnPacker()
+	return pool.popUnPacker()
 }
 
 func PushUnPacker(unpacker *UnPacker) {
-	if pool != nil {
-		pool.pushUnPacker(unpacker)
-	}
+	pool.pushUnPacker(unpacker)
 }
@@ -0,0 +1,158 @@
+package msgpack
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	encodeFlushSize = 4096
+	decodeBuffSize  = 4096
+)
+
+var (
+	ErrDecodeTooLarge = errors.New("Err DecodeTooLarge")
+)
+
+//Encoder 打包到io.Writer,缓冲超过flushsize自动写出,最后要调用Flush
+type Encoder struct {
+	Packer
+	wr        io.Writer
+	flushsize int
+	err       error
+}
+
+//SetFlushSize 缓冲到多少字节写一次
+func (enc *Encoder) SetFlushSize(size int) {
+	if size > 0 {
+		enc.flushsize = size
+	}
+}
+
+//Flush 把缓冲的数据写出去,写失败以后的数据都丢掉
+func (enc *Encoder) Flush() error {
+	if len(enc.buf) > 0 && enc.err == nil {
+		_, enc.err = enc.wr.Write(enc.buf)
+	}
+	enc.buf = enc.buf[:0]
+	return enc.err
+}
+
+//Encode 打包一个值,够flushsize就写出去
+func (enc *Encoder) Encode(v interface{}) error {
+	if err := enc.PackValue(v); err != nil {
+		return err
+	}
+	enc.spill()
+	return enc.err
+}
+
+//Err 第一次写失败的错误
+func (enc *Encoder) Err() error {
+	return enc.err
+}
+
+//decodeSource 流式解码的数据来源,in不够时从rd补
+type decodeSource struct {
+	rd      io.Reader
+	buf     []byte
+	maxsize int
+	err     error
+}
+
+//Decoder 从io.Reader里一个接一个解码
+type Decoder struct {
+	UnPacker
+}
+
+//SetMaxSize 单个字段最多缓冲多少字节,超过返回ErrDecodeTooLarge,0不限制
+func (dec *Decoder) SetMaxSize(size int) {
+	dec.src.maxsize = size
+}
+
+//More 后面还有没有数据,读完了或者出错返回false
+func (dec *Decoder) More() bool {
+	return dec.pos < len(dec.in) || dec.fill(1)
+}
+
+//Decode 解下一个值,和Unmarshal一样v必须是指针
+func (dec *Decoder) Decode(v interface{}) error {
+	dec.err = nil
+	if !dec.More() {
+		if dec.src.err != nil && dec.src.err != io.EOF {
+			return dec.src.err
+		}
+		return io.EOF
+	}
+	if err := dec.UnPackValue(v); err != nil {
+		//值没读完数据就没了
+		if dec.src.err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if dec.src.err != nil && dec.src.err != ErrDecodeTooLarge {
+			return dec.src.err
+		}
+		return err
+	}
+	return nil
+}
+
+//Err 读数据的错误,正常读完时是最后一个值解码的错误
+func (dec *Decoder) Err() error {
+	if dec.src.err != nil && dec.src.err != io.EOF {
+		return dec.src.err
+	}
+	return dec.UnPacker.Err()
+}
+
+//fill 保证至少有n个字节没读,读过的挪掉
+func (unpacker *UnPacker) fill(n int) bool {
+	src := unpacker.src
+	if src == nil || src.err != nil {
+		return false
+	}
+	if src.maxsize > 0 && n > src.maxsize {
+		src.err = ErrDecodeTooLarge
+		unpacker.setCause(ErrDecodeTooLarge)
+		return false
+	}
+	if limit := unpacker.limits.MaxSize; limit > 0 && unpacker.base+unpacker.pos+n > limit {
+		unpacker.setCause(ErrDecodeLimit)
+		return false
+	}
+	unpacker.base += unpacker.pos
+	rest := len(unpacker.in) - unpacker.pos
+	buf := src.buf
+	if cap(buf) < rest || cap(buf) < decodeBuffSize {
+		size := rest
+		if size < decodeBuffSize {
+			size = decodeBuffSize
+		}
+		buf = make([]byte, rest, size)
+	} else {
+		buf = buf[:rest]
+	}
+	copy(buf, unpacker.in[unpacker.pos:])
+	for len(buf) < n {
+		if len(buf) == cap(buf) {
+			//按读到的数据翻倍,长度头可能是假的,不能一次按n分配
+			size := 2 * cap(buf)
+			if size > n {
+				size = n
+			}
+			grown := make([]byte, len(buf), size)
+			copy(grown, buf)
+			buf = grown
+		}
+		m, err := src.rd.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+m]
+		if err != nil {
+			src.err = err
+			break
+		}
+	}
+	src.buf = buf
+	unpacker.in = buf
+	unpacker.pos = 0
+	return len(buf) >= n
+}
@@ -0,0 +1,505 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+//legacyPacker 改写之前的打包代码,只留小端部分,用来对比旧格式
+type legacyPacker struct {
+	out bytes.Buffer
+}
+
+func (packer *legacyPacker) write(i interface{}) *legacyPacker {
+	binary.Write(&packer.out, binary.LittleEndian, i)
+	return packer
+}
+
+func (packer *legacyPacker) packInt64(value int64) {
+	if value >= 0 {
+		if value <= int64(MAX_7BIT) {
+			packer.write(uint8(value) | MP_FIXNUM)
+		} else if value <= int64(MAX_15BIT) {
+			packer.write(MP_INT16).write(int16(value))
+		} else if value <= int64(MAX_31BIT) {
+			packer.write(MP_INT32).write(int32(value))
+		} else {
+			packer.write(MP_INT64).write(value)
+		}
+	} else {
+		if value >= -(int64(MAX_5BIT) + 1) {
+			packer.write(int8(int16(value) | int16(MP_NEGATIVE_FIXNUM)))
+		} else if value >= -(int64(MAX_7BIT) + 1) {
+			packer.write(MP_INT8).write(int8(value))
+		} else if value >= -(int64(MAX_15BIT) + 1) {
+			packer.write(MP_INT16).write(int16(value))
+		} else if value >= -(int64(MAX_31BIT) + 1) {
+			packer.write(MP_INT32).write(int32(value))
+		} else {
+			packer.write(MP_INT64).write(value)
+		}
+	}
+}
+
+func (packer *legacyPacker) packUInt64(value uint64) {
+	if value <= uint64(MAX_7BIT) {
+		packer.write(int8(value) | int8(MP_FIXNUM))
+	} else if value <= uint64(MAX_8BIT) {
+		packer.write(MP_UINT8).write(uint8(value))
+	} else if value <= uint64(MAX_16BIT) {
+		packer.write(MP_UINT16).write(uint16(value))
+	} else if value <= uint64(MAX_32BIT) {
+		packer.write(MP_UINT32).write(uint32(value))
+	} else {
+		packer.write(MP_UINT64).write(value)
+	}
+}
+
+func (packer *legacyPacker) packBytes(value []byte) {
+	length := uint32(len(value))
+	if length <= MAX_5BIT {
+		packer.write(int8(uint8(length) | MP_FIXRAW))
+	} else if length <= MAX_16BIT {
+		packer.write(MP_RAW16).write(int16(length))
+	} else {
+		packer.write(MP_RAW32).write(int32(length))
+	}
+	packer.out.Write(value)
+}
+
+//brief 长数据只打印开头
+func brief(data []byte) []byte {
+	if len(data) > 16 {
+		return data[:16]
+	}
+	return data
+}
+
+var legacyInts = []int64{
+	0, 1, 31, 32, 127, 128, 255, 256, 32767, 32768, 65535, 65536,
+	math.MaxInt32, math.MaxInt32 + 1, math.MaxUint32, math.MaxUint32 + 1, math.MaxInt64,
+	-1, -32, -33, -128, -129, -32768, -32769, math.MinInt32, math.MinInt32 - 1, math.MinInt64,
+}
+
+var legacyLens = []int{0, 1, 31, 32, 40, 255, 256, 65535, 65536}
+
+//TestLegacyBytes 旧格式要和改写前的打包结果逐字节一致,没升级的客户端才能解
+func TestLegacyBytes(t *testing.T) {
+	check := func(name string, pack func(*Packer), legacy func(*legacyPacker)) {
+		t.Helper()
+		packer := NewPacker()
+		pack(packer)
+		want := &legacyPacker{}
+		legacy(want)
+		if !bytes.Equal(packer.GetBuffer(), want.out.Bytes()) {
+			t.Errorf("%s: got % x, want % x", name, brief(packer.GetBuffer()), brief(want.out.Bytes()))
+		}
+	}
+	for _, v := range legacyInts {
+		v := v
+		check("PackInt64", func(p *Packer) { p.PackInt64(v) }, func(p *legacyPacker) { p.packInt64(v) })
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			check("PackInt32", func(p *Packer) { p.PackInt32(int32(v)) }, func(p *legacyPacker) { p.packInt64(v) })
+		}
+		if v >= 0 {
+			check("PackUInt64", func(p *Packer) { p.PackUInt64(uint64(v)) }, func(p *legacyPacker) { p.packUInt64(uint64(v)) })
+			if v <= math.MaxUint32 {
+				check("PackUInt32", func(p *Packer) { p.PackUInt32(uint32(v)) }, func(p *legacyPacker) { p.packUInt64(uint64(v)) })
+			}
+		}
+	}
+	check("PackUInt64", func(p *Packer) { p.PackUInt64(math.MaxUint64) }, func(p *legacyPacker) { p.packUInt64(math.MaxUint64) })
+	for _, n := range legacyLens {
+		data := bytes.Repeat([]byte{'x'}, n)
+		str := strings.Repeat("x", n)
+		check("PackBytes", func(p *Packer) { p.PackBytes(data) }, func(p *legacyPacker) { p.packBytes(data) })
+		check("PackString", func(p *Packer) { p.PackString(str) }, func(p *legacyPacker) { p.packBytes([]byte(str)) })
+	}
+	for _, f := range []float64{0, 1.5, -2.25, math.MaxFloat64, math.Inf(-1)} {
+		f := f
+		check("PackDouble", func(p *Packer) { p.PackDouble(f) }, func(p *legacyPacker) { p.write(MP_DOUBLE).write(math.Float64bits(f)) })
+		check("PackFloat", func(p *Packer) { p.PackFloat(float32(f)) }, func(p *legacyPacker) { p.write(MP_FLOAT).write(math.Float32bits(float32(f))) })
+	}
+	check("PackBool", func(p *Packer) { p.PackBool(true) }, func(p *legacyPacker) { p.write(MP_TRUE) })
+	check("PackBool", func(p *Packer) { p.PackBool(false) }, func(p *legacyPacker) { p.write(MP_FALSE) })
+}
+
+//TestLegacyMessage 一条消息里的各种字段连在一起也一致,默认就是旧格式
+func TestLegacyMessage(t *testing.T) {
+	packer := PopPacker()
+	defer PushPacker(packer)
+	packer.PackUInt32(1001)
+	packer.PackString(strings.Repeat("n", 40))
+	packer.PackBytes([]byte{1, 2, 3})
+	packer.PackInt64(-70000)
+
+	want := &legacyPacker{}
+	want.packUInt64(1001)
+	want.packBytes([]byte(strings.Repeat("n", 40)))
+	want.packBytes([]byte{1, 2, 3})
+	want.packInt64(-70000)
+	if !bytes.Equal(packer.GetBuffer(), want.out.Bytes()) {
+		t.Fatalf("got % x\nwant % x", packer.GetBuffer(), want.out.Bytes())
+	}
+
+	unpacker := PopUnPacker()
+	defer PushUnPacker(unpacker)
+	unpacker.Attatch(want.out.Bytes())
+	if r, id := unpacker.UnPackUInt32(); r != 0 || id != 1001 {
+		t.Fatalf("id %d %d", r, id)
+	}
+	if r, name := unpacker.UnPackString(); r != 0 || len(name) != 40 {
+		t.Fatalf("name %d %q", r, name)
+	}
+	if r, data := unpacker.UnPackBytes(); r != 0 || !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Fatalf("data %d %v", r, data)
+	}
+	if r, v := unpacker.UnPackInt64(); r != 0 || v != -70000 {
+		t.Fatalf("value %d %d", r, v)
+	}
+}
+
+//TestSpecHeaders 标准格式按规范选最短的头
+func TestSpecHeaders(t *testing.T) {
+	packer := NewPacker()
+	packer.SetMode(ModeSpec)
+	packer.PackUInt32(0x01020304)
+	packer.PackString(strings.Repeat("s", 40))
+	packer.PackBytes([]byte{7})
+	want := []byte{MP_UINT32, 1, 2, 3, 4, MP_STR8, 40}
+	want = append(want, strings.Repeat("s", 40)...)
+	want = append(want, MP_BIN8, 1, 7)
+	if !bytes.Equal(packer.GetBuffer(), want) {
+		t.Fatalf("got % x\nwant % x", packer.GetBuffer(), want)
+	}
+}
+
+//refInts 按规范列出一个整数所有合法的编码,用来检查解码
+func refInts(v *big.Int, mode int) [][]byte {
+	var order binary.ByteOrder = binary.LittleEndian
+	if mode == ModeSpec {
+		order = binary.BigEndian
+	}
+	var encs [][]byte
+	add := func(header uint8, size int, bits uint64) {
+		data := make([]byte, 1+size)
+		data[0] = header
+		switch size {
+		case 1:
+			data[1] = uint8(bits)
+		case 2:
+			order.PutUint16(data[1:], uint16(bits))
+		case 4:
+			order.PutUint32(data[1:], uint32(bits))
+		case 8:
+			order.PutUint64(data[1:], bits)
+		}
+		encs = append(encs, data)
+	}
+	if v.IsInt64() {
+		i := v.Int64()
+		if i >= 0 && i <= 127 {
+			add(uint8(i), 0, 0)
+		}
+		if i >= -32 && i < 0 {
+			add(uint8(int8(i)), 0, 0)
+		}
+		if i >= math.MinInt8 && i <= math.MaxInt8 {
+			add(MP_INT8, 1, uint64(i))
+		}
+		if i >= math.MinInt16 && i <= math.MaxInt16 {
+			add(MP_INT16, 2, uint64(i))
+		}
+		if i >= math.MinInt32 && i <= math.MaxInt32 {
+			add(MP_INT32, 4, uint64(i))
+		}
+		add(MP_INT64, 8, uint64(i))
+	}
+	if v.Sign() >= 0 && v.IsUint64() {
+		u := v.Uint64()
+		if u <= math.MaxUint8 {
+			add(MP_UINT8, 1, u)
+		}
+		if u <= math.MaxUint16 {
+			add(MP_UINT16, 2, u)
+		}
+		if u <= math.MaxUint32 {
+			add(MP_UINT32, 4, u)
+		}
+		add(MP_UINT64, 8, u)
+	}
+	return encs
+}
+
+type intTarget struct {
+	name     string
+	min, max *big.Int
+	unpack   func(*UnPacker) (int, *big.Int)
+	pack     func(*Packer, *big.Int)
+}
+
+func bigU(v uint64) *big.Int {
+	return new(big.Int).SetUint64(v)
+}
+
+var intTargets = []intTarget{
+	{"int8", big.NewInt(math.MinInt8), big.NewInt(math.MaxInt8),
+		func(u *UnPacker) (int, *big.Int) { r, v := u.UnPackInt8(); return r, big.NewInt(int64(v)) },
+		func(p *Packer, v *big.Int) { p.PackInt8(int8(v.Int64())) }},
+	{"int16", big.NewInt(math.MinInt16), big.NewInt(math.MaxInt16),
+		func(u *UnPacker) (int, *big.Int) { r, v := u.UnPackInt16(); return r, big.NewInt(int64(v)) },
+		func(p *Packer, v *big.Int) { p.PackInt16(int16(v.Int64())) }},
+	{"int32", big.NewInt(math.MinInt32), big.NewInt(math.MaxInt32),
+		func(u *UnPacker) (int, *big.Int) { r, v := u.UnPackInt32(); return r, big.NewInt(int64(v)) },
+		func(p *Packer, v *big.Int) { p.PackInt32(int32(v.Int64())) }},
+	{"int64", big.NewInt(math.MinInt64), big.NewInt(math.MaxInt64),
+		func(u *UnPacker) (int, *big.Int) { r, v := u.UnPackInt64(); return r, big.NewInt(v) },
+		func(p *Packer, v *big.Int) { p.PackInt64(v.Int64()) }},
+	{"uint8", big.NewInt(0), bigU(math.MaxUint8),
+		func(u *UnPacker) (int, *big.Int) { r, v := u.UnPackUInt8(); return r, bigU(uint64(v)) },
+		func(p *Packer, v *big.Int) { p.PackUInt8(uint8(v.Uint64())) }},
+	{"uint16", big.NewInt(0), bigU(math.MaxUint16),
+		func(u *UnPacker) (int, *big.Int) { r, v := u.UnPackUInt16(); return r, bigU(uint64(v)) },
+		func(p *Packer, v *big.Int) { p.PackUInt16(uint16(v.Uint64())) }},
+	{"uint32", big.NewInt(0), bigU(math.MaxUint32),
+		func(u *UnPacker) (int, *big.Int) { r, v := u.UnPackUInt32(); return r, bigU(uint64(v)) },
+		func(p *Packer, v *big.Int) { p.PackUInt32(uint32(v.Uint64())) }},
+	{"uint64", big.NewInt(0), bigU(math.MaxUint64),
+		func(u *UnPacker) (int, *big.Int) { r, v := u.UnPackUInt64(); return r, bigU(v) },
+		func(p *Packer, v *big.Int) { p.PackUInt64(v.Uint64()) }},
+}
+
+//intValues 每种宽度的边界和边界两边
+func intValues() []*big.Int {
+	var values []*big.Int
+	for _, v := range []int64{
+		0, 1, 31, 32, 127, 128, 255, 256, 32767, 32768, 65535, 65536,
+		math.MaxInt32, math.MaxInt32 + 1, math.MaxUint32, math.MaxUint32 + 1, math.MaxInt64,
+		-1, -32, -33, -128, -129, -32768, -32769, math.MinInt32, math.MinInt32 - 1, math.MinInt64,
+	} {
+		values = append(values, big.NewInt(v))
+	}
+	return append(values, bigU(math.MaxInt64+1), bigU(math.MaxUint64))
+}
+
+//TestIntegerMatrix 每个整数的每种编码解到每种宽度,放得下的要解对,放不下的是ErrDecodeOverflow
+func TestIntegerMatrix(t *testing.T) {
+	for _, mode := range []int{ModeLegacy, ModeSpec} {
+		for _, v := range intValues() {
+			for _, enc := range refInts(v, mode) {
+				for _, target := range intTargets {
+					unpacker := NewUnPacker()
+					unpacker.SetMode(mode)
+					unpacker.Attatch(enc)
+					r, got := target.unpack(unpacker)
+					fits := v.Cmp(target.min) >= 0 && v.Cmp(target.max) <= 0
+					switch {
+					case fits && (r != 0 || got.Cmp(v) != 0):
+						t.Errorf("mode %d %s from % x: got %d %v, want %v", mode, target.name, enc, r, got, v)
+					case !fits && r == 0:
+						t.Errorf("mode %d %s from % x: got %v, want overflow", mode, target.name, enc, got)
+					case !fits && !errors.Is(unpacker.Err(), ErrDecodeOverflow):
+						t.Errorf("mode %d %s from % x: error %v, want ErrDecodeOverflow", mode, target.name, enc, unpacker.Err())
+					}
+				}
+			}
+		}
+	}
+}
+
+//TestIntegerRoundTrip 每种宽度打包出来的是合法编码,再按同样宽度解回来
+func TestIntegerRoundTrip(t *testing.T) {
+	for _, mode := range []int{ModeLegacy, ModeSpec} {
+		for _, v := range intValues() {
+			valid := refInts(v, mode)
+			for _, target := range intTargets {
+				if v.Cmp(target.min) < 0 || v.Cmp(target.max) > 0 {
+					continue
+				}
+				packer := NewPacker()
+				packer.SetMode(mode)
+				target.pack(packer, v)
+				data := packer.GetBuffer()
+				found := false
+				for _, enc := range valid {
+					found = found || bytes.Equal(enc, data)
+				}
+				if !found {
+					t.Errorf("mode %d Pack%s(%v) = % x, not a valid encoding", mode, target.name, v, data)
+				}
+				unpacker := NewUnPacker()
+				unpacker.SetMode(mode)
+				unpacker.Attatch(data)
+				if r, got := target.unpack(unpacker); r != 0 || got.Cmp(v) != 0 {
+					t.Errorf("mode %d %s round trip %v: got %d %v", mode, target.name, v, r, got)
+				}
+			}
+		}
+	}
+}
+
+//TestIntegerWrongType 不是整数的值解成整数是ErrDecodeType,不会被当成数字
+func TestIntegerWrongType(t *testing.T) {
+	packer := NewPacker()
+	inputs := [][]byte{}
+	for _, pack := range []func(){
+		func() { packer.PackFloat(1) },
+		func() { packer.PackDouble(1) },
+		func() { packer.PackString("1") },
+		func() { packer.PackBool(true) },
+		func() { packer.PackNil() },
+		func() { packer.PackArrayHeader(1) },
+	} {
+		packer.ClearBuffer()
+		pack()
+		inputs = append(inputs, append([]byte(nil), packer.GetBuffer()...))
+	}
+	inputs = append(inputs, []byte{MP_INT32, 1, 2}, []byte{})
+	for _, data := range inputs {
+		for _, target := range intTargets {
+			unpacker := NewUnPacker()
+			unpacker.Attatch(data)
+			if r, _ := target.unpack(unpacker); r == 0 {
+				t.Errorf("%s from % x: want failure", target.name, data)
+			} else if unpacker.Err() == nil {
+				t.Errorf("%s from % x: no error recorded", target.name, data)
+			}
+		}
+	}
+}
+
+//TestJSONMode 大端的包要按ModeSpec转json,按旧格式解出来的数字不对
+func TestJSONMode(t *testing.T) {
+	packer := NewPacker()
+	packer.SetMode(ModeSpec)
+	packer.PackMapHeader(1)
+	packer.PackString("gold")
+	packer.PackUInt32(70000)
+	data := packer.GetBuffer()
+	out, err := ToJSONMode(data, ModeSpec)
+	if err != nil || string(out) != `{"gold":70000}` {
+		t.Fatalf("spec json %s %v", out, err)
+	}
+	if out, err := ToJSON(data); err == nil && string(out) == `{"gold":70000}` {
+		t.Fatal("legacy decode of big-endian data matched")
+	}
+	values, err := DecodeAllMode(append(append([]byte(nil), data...), data...), ModeSpec)
+	if err != nil || len(values) != 2 {
+		t.Fatalf("decode all %v %v", values, err)
+	}
+	if value, err := DecodeAnyMode(data, ModeSpec); err != nil || value.(map[string]interface{})["gold"] != uint32(70000) {
+		t.Fatalf("decode any %#v %v", value, err)
+	}
+}
+
+//TestInField 字段名从里往外拼,成功的时候不改错误
+func TestInField(t *testing.T) {
+	packer := NewPacker()
+	packer.PackUInt32(1)
+	packer.PackString("x")
+	unpacker := NewUnPacker()
+	unpacker.Attatch(packer.GetBuffer())
+	if r, _ := unpacker.UnPackUInt32(); unpacker.InField(r, "id") != 0 {
+		t.Fatal("ok value failed")
+	}
+	r, _ := unpacker.UnPackUInt32()
+	if unpacker.InField(unpacker.InField(r, "hp"), "Role") == 0 {
+		t.Fatal("r not returned")
+	}
+	var derr *DecodeError
+	if !errors.As(unpacker.Err(), &derr) || derr.Field != "Role.hp" || derr.Offset != 1 {
+		t.Fatalf("err %v", unpacker.Err())
+	}
+}
+
+type numKeyStruct struct {
+	Id   uint32 `msgpack:"1"`
+	Name string `msgpack:"2"`
+}
+
+//TestUnmarshalSignedKey 数字tag的键打成有符号格式也能找到字段,负数的跳过
+func TestUnmarshalSignedKey(t *testing.T) {
+	for _, mode := range []int{ModeLegacy, ModeSpec} {
+		packer := NewPacker()
+		packer.SetMode(mode)
+		packer.PackMapHeader(5)
+		packer.PackInt8(1)
+		packer.PackUInt32(7)
+		packer.PackInt64(2)
+		packer.PackString("name")
+		packer.PackInt16(-1)
+		packer.PackString("skip")
+		packer.PackInt32(3)
+		packer.PackString("unknown")
+		packer.PackUInt64(math.MaxUint64)
+		packer.PackNil()
+		unpacker := NewUnPacker()
+		unpacker.SetMode(mode)
+		unpacker.Attatch(packer.GetBuffer())
+		var v numKeyStruct
+		if err := unpacker.UnPackValue(&v); err != nil || v.Id != 7 || v.Name != "name" {
+			t.Fatalf("mode %d %+v %v", mode, v, err)
+		}
+	}
+}
+
+type cycleNode struct {
+	Name string
+	Next *cycleNode
+}
+
+//TestMarshalCycle 有环的值返回错误,不能栈溢出,很深但没有环的照常打包
+func TestMarshalCycle(t *testing.T) {
+	node := &cycleNode{Name: "a"}
+	node.Next = &cycleNode{Name: "b", Next: node}
+	if _, err := Marshal(node); err != ErrMarshalCycle {
+		t.Fatalf("pointer cycle: %v", err)
+	}
+	m := map[string]interface{}{}
+	m["self"] = m
+	if _, err := Marshal(m); err != ErrMarshalCycle {
+		t.Fatalf("map cycle: %v", err)
+	}
+	s := make([]interface{}, 1)
+	s[0] = s
+	if _, err := Marshal(s); err != ErrMarshalCycle {
+		t.Fatalf("slice cycle: %v", err)
+	}
+
+	var deep *cycleNode
+	for i := 0; i < 3*marshalCycleLevel; i++ {
+		deep = &cycleNode{Name: "n", Next: deep}
+	}
+	if _, err := Marshal(deep); err != nil {
+		t.Fatalf("deep list: %v", err)
+	}
+	//同一个值出现两次不是环
+	shared := []int{1, 2}
+	if _, err := Marshal([][]int{shared, shared}); err != nil {
+		t.Fatalf("shared slice: %v", err)
+	}
+	packer := PopPacker()
+	packer.PackValue(node)
+	if packer.reflevel != 0 || len(packer.refseen) != 0 {
+		t.Fatalf("level %d seen %d after error", packer.reflevel, len(packer.refseen))
+	}
+	PushPacker(packer)
+}
+
+//TestMarshalNaNKey NaN做键的map也要能打包
+func TestMarshalNaNKey(t *testing.T) {
+	m := map[interface{}]interface{}{math.NaN(): 1, math.NaN(): 2, "a": nil}
+	data, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := DecodeAny(data)
+	if err != nil || len(value.(map[interface{}]interface{})) != 3 {
+		t.Fatalf("decode %v %v", value, err)
+	}
+}
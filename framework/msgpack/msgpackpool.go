@@ -1,80 +1,104 @@
 package msgpack
 
 import (
-	"g_server/framework/com"
+	"sync"
+	"sync/atomic"
 )
 
-type msgPackUnPackPool struct {
-	packChan   chan *Packer
-	unpackChan chan *UnPacker
-	run        bool
-}
+//packSizes Packer缓冲区按容量分级,放回时归到不超过容量的最大一级,取的时候按需要的大小找
+var packSizes = [...]int{PackbuffSize, 4 << 10, 32 << 10, 256 << 10}
 
-func (pool *msgPackUnPackPool) destory() {
-	if !pool.run {
-		return
-	}
-	pool.run = false
-	close(pool.packChan)
-	close(pool.unpackChan)
+//PoolStats 池子的命中统计,Drop是缓冲区太大或者太小没有放回去的次数
+type PoolStats struct {
+	PackerHit    uint64
+	PackerMiss   uint64
+	PackerDrop   uint64
+	UnPackerHit  uint64
+	UnPackerMiss uint64
 }
 
-func (pool *msgPackUnPackPool) init(pnum int) {
-	if pool.run {
-		return
-	}
-	pool.run = true
-	pool.packChan = make(chan *Packer, pnum)
-	pool.unpackChan = make(chan *UnPacker, pnum)
+type msgPackUnPackPool struct {
+	packs   [len(packSizes)]sync.Pool
+	unpacks sync.Pool
+	maxsize int64
+	stats   PoolStats
 }
 
-func (pool *msgPackUnPackPool) pushPacker(packer *Packer) {
-	if pool.run {
-		com.SafeCall(func() {
-			select {
-			case pool.packChan <- packer:
-			default:
-				return
-			}
-		})
+func newMsgPackUnPackPool() *msgPackUnPackPool {
+	return &msgPackUnPackPool{maxsize: int64(packSizes[len(packSizes)-1])}
+}
 
+//packClass 能放下size的最小一级,超过最大一级返回-1
+func packClass(size int) int {
+	for i, n := range packSizes {
+		if size <= n {
+			return i
+		}
 	}
+	return -1
 }
 
-func (pool *msgPackUnPackPool) pushUnPacker(unpacker *UnPacker) {
-	if pool.run {
-		com.SafeCall(func() {
-			select {
-			case pool.unpackChan <- unpacker:
-			default:
-				return
-			}
-		})
+func (pool *msgPackUnPackPool) popPacker(size int) *Packer {
+	class := packClass(size)
+	if class < 0 {
+		atomic.AddUint64(&pool.stats.PackerMiss, 1)
+		return &Packer{buf: make([]byte, 0, size)}
 	}
+	if packer, ok := pool.packs[class].Get().(*Packer); ok {
+		atomic.AddUint64(&pool.stats.PackerHit, 1)
+		return packer
+	}
+	atomic.AddUint64(&pool.stats.PackerMiss, 1)
+	return &Packer{buf: make([]byte, 0, packSizes[class])}
 }
 
-func (pool *msgPackUnPackPool) popPacker() *Packer {
-	if pool.run {
-		select {
-		case packer, ok := <-pool.packChan:
-			if ok {
-				return packer
-			}
-		default:
+func (pool *msgPackUnPackPool) pushPacker(packer *Packer) {
+	size := cap(packer.buf)
+	//比最小一级还小的不放回去,取出来的缓冲区至少有那一级的大小
+	if size < packSizes[0] || int64(size) > atomic.LoadInt64(&pool.maxsize) {
+		atomic.AddUint64(&pool.stats.PackerDrop, 1)
+		return
+	}
+	class := 0
+	for i, n := range packSizes {
+		if size >= n {
+			class = i
 		}
 	}
-	return NewPacker()
+	packer.buf = packer.buf[:0]
+	packer.mode = ModeLegacy
+	packer.enc = nil
+	packer.reflevel = 0
+	packer.refseen = nil
+	pool.packs[class].Put(packer)
 }
 
 func (pool *msgPackUnPackPool) popUnPacker() *UnPacker {
-	if pool.run {
-		select {
-		case unpacker, ok := <-pool.unpackChan:
-			if ok {
-				return unpacker
-			}
-		default:
-		}
+	if unpacker, ok := pool.unpacks.Get().(*UnPacker); ok {
+		atomic.AddUint64(&pool.stats.UnPackerHit, 1)
+		return unpacker
 	}
+	atomic.AddUint64(&pool.stats.UnPackerMiss, 1)
 	return NewUnPacker()
 }
+
+func (pool *msgPackUnPackPool) pushUnPacker(unpacker *UnPacker) {
+	//不要拖住解过的数据
+	unpacker.in = nil
+	unpacker.cause = nil
+	unpacker.err = nil
+	//用的人可能改过限制和编码方式
+	unpacker.limits = defaultLimits
+	unpacker.mode = ModeLegacy
+	pool.unpacks.Put(unpacker)
+}
+
+func (pool *msgPackUnPackPool) getStats() PoolStats {
+	return PoolStats{
+		PackerHit:    atomic.LoadUint64(&pool.stats.PackerHit),
+		PackerMiss:   atomic.LoadUint64(&pool.stats.PackerMiss),
+		PackerDrop:   atomic.LoadUint64(&pool.stats.PackerDrop),
+		UnPackerHit:  atomic.LoadUint64(&pool.stats.UnPackerHit),
+		UnPackerMiss: atomic.LoadUint64(&pool.stats.UnPackerMiss),
+	}
+}
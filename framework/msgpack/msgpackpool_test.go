@@ -0,0 +1,111 @@
+package msgpack
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+//TestPoolPopSize 取出来的缓冲区不能比要的小,小缓冲区放回去也一样
+func TestPoolPopSize(t *testing.T) {
+	pool := newMsgPackUnPackPool()
+	for _, size := range []int{0, 1, 64, 511, 512, 513, 4096, 5000, 40000, 300000} {
+		pool.pushPacker(&Packer{buf: make([]byte, 0, size)})
+	}
+	for _, size := range []int{0, 1, 512, 513, 4096, 4097, 32 << 10, 256 << 10, 300000} {
+		for i := 0; i < 4; i++ {
+			packer := pool.popPacker(size)
+			if cap(packer.buf) < size {
+				t.Fatalf("pop %d got cap %d", size, cap(packer.buf))
+			}
+			pool.pushPacker(packer)
+		}
+	}
+}
+
+//TestPoolDrop 比最小一级小和超过上限的都不放回去
+func TestPoolDrop(t *testing.T) {
+	pool := newMsgPackUnPackPool()
+	pool.pushPacker(&Packer{buf: make([]byte, 0, 100)})
+	pool.pushPacker(&Packer{buf: make([]byte, 0, 300000)})
+	pool.pushPacker(&Packer{buf: make([]byte, 0, 512)})
+	if stats := pool.getStats(); stats.PackerDrop != 2 {
+		t.Fatalf("drop %d, want 2", stats.PackerDrop)
+	}
+	pool.maxsize = 1024
+	pool.pushPacker(&Packer{buf: make([]byte, 0, 4096)})
+	if stats := pool.getStats(); stats.PackerDrop != 3 {
+		t.Fatalf("drop %d after lowering max, want 3", stats.PackerDrop)
+	}
+}
+
+//TestPoolReset 放回去的Packer和UnPacker不带上次的状态
+func TestPoolReset(t *testing.T) {
+	pool := newMsgPackUnPackPool()
+	enc := NewEncoder(&bytes.Buffer{})
+	packer := &Packer{buf: make([]byte, 0, 512), enc: enc}
+	packer.SetMode(ModeSpec)
+	packer.PackString("left over")
+	pool.pushPacker(packer)
+	if len(packer.buf) != 0 || packer.mode != ModeLegacy || packer.enc != nil {
+		t.Fatalf("packer not reset: len %d mode %d enc %v", len(packer.buf), packer.mode, packer.enc)
+	}
+
+	unpacker := NewUnPacker()
+	unpacker.SetMode(ModeSpec)
+	unpacker.SetLimits(DecodeLimits{MaxBytes: 1})
+	unpacker.Attatch([]byte{0xc4, 0x02, 1, 2})
+	if r, _ := unpacker.UnPackBytes(); r == 0 {
+		t.Fatal("limit not applied")
+	}
+	pool.pushUnPacker(unpacker)
+	if unpacker.in != nil || unpacker.err != nil || unpacker.cause != nil ||
+		unpacker.mode != ModeLegacy || unpacker.limits != defaultLimits {
+		t.Fatal("unpacker not reset")
+	}
+}
+
+//TestPoolStats 命中加没命中等于取的次数
+func TestPoolStats(t *testing.T) {
+	pool := newMsgPackUnPackPool()
+	for i := 0; i < 10; i++ {
+		pool.pushPacker(pool.popPacker(100))
+		pool.pushUnPacker(pool.popUnPacker())
+	}
+	stats := pool.getStats()
+	if stats.PackerHit+stats.PackerMiss != 10 || stats.UnPackerHit+stats.UnPackerMiss != 10 {
+		t.Fatalf("stats %+v", stats)
+	}
+	if stats.PackerDrop != 0 {
+		t.Fatalf("dropped %d", stats.PackerDrop)
+	}
+}
+
+//TestPoolConcurrent 多个协程同时取放,用-race跑
+func TestPoolConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				packer := PopPackerSize(i * 16)
+				packer.SetMode(g % 2)
+				packer.PackUInt32(uint32(i))
+				packer.PackString("pool")
+				data := append([]byte(nil), packer.GetBuffer()...)
+				PushPacker(packer)
+
+				unpacker := PopUnPacker()
+				unpacker.SetMode(g % 2)
+				unpacker.Attatch(data)
+				r, v := unpacker.UnPackUInt32()
+				if r != 0 || v != uint32(i) {
+					t.Errorf("decode %d got %d r %d", i, v, r)
+				}
+				PushUnPacker(unpacker)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,43 @@
+package protocolbase
+
+import (
+	"reflect"
+	"sync"
+)
+
+//MsgDesc 消息描述,协议层注册,调试输出时用来显示消息名和解包
+type MsgDesc struct {
+	Id     uint32
+	Name   string
+	Create func() IMsg
+}
+
+var (
+	msgdesclock sync.RWMutex
+	msgdescs    = make(map[uint32]*MsgDesc)
+)
+
+//RegMsgDesc 注册消息描述,同一个消息号后注册的覆盖前面的
+//pcgtool生成的代码不会注册,生成的消息用RegMsgs一次注册
+func RegMsgDesc(id uint32, name string, create func() IMsg) {
+	msgdesclock.Lock()
+	defer msgdesclock.Unlock()
+	msgdescs[id] = &MsgDesc{Id: id, Name: name, Create: create}
+}
+
+//FindMsgDesc 没有注册返回nil
+func FindMsgDesc(id uint32) *MsgDesc {
+	msgdesclock.RLock()
+	defer msgdesclock.RUnlock()
+	return msgdescs[id]
+}
+
+//RegMsgs 按GetProId和类型名注册,每种消息传一个实例,比如RegMsgs(&P_C2G_Login{}, &P_G2C_Login{})
+func RegMsgs(msgs ...IMsg) {
+	for _, msg := range msgs {
+		typ := reflect.TypeOf(msg).Elem()
+		RegMsgDesc(msg.GetProId(), typ.Name(), func() IMsg {
+			return reflect.New(typ).Interface().(IMsg)
+		})
+	}
+}
@@ -12,17 +12,27 @@ type IPacker interface {
 	PackDouble(float64)
 	PackBool(bool)
 	PackString(string)
+	PackInt8(int8)
+	PackInt16(int16)
 	PackInt32(int32)
 	PackInt64(int64)
+	PackUInt8(uint8)
+	PackUInt16(uint16)
 	PackUInt32(uint32)
 	PackUInt64(uint64)
 	PackBytes([]byte)
+	PackArrayHeader(uint32)
+	PackMapHeader(uint32)
 }
 
 type IUnpacker interface {
 	Attatch([]byte)
 	UnPackUInt64() (int, uint64)
 	UnPackUInt32() (int, uint32)
+	UnPackUInt16() (int, uint16)
+	UnPackUInt8() (int, uint8)
+	UnPackInt8() (int, int8)
+	UnPackInt16() (int, int16)
 	UnPackInt32() (int, int32)
 	UnPackInt64() (int, int64)
 	UnPackString() (int, string)
@@ -30,4 +40,8 @@ type IUnpacker interface {
 	UnPackDouble() (int, float64)
 	UnPackBool() (int, bool)
 	UnPackBytes() (int, []byte)
+	UnPackArrayHeader() (int, uint32)
+	UnPackMapHeader() (int, uint32)
+	Err() error
+	InField(int, string) int
 }
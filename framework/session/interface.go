@@ -1,10 +1,13 @@
 package session
 
 import (
+	"errors"
+	"g_server/framework/com"
 	"g_server/framework/datastruct"
 	"g_server/framework/gnet"
 	"g_server/framework/msgpack"
 	"g_server/framework/protocolbase"
+	"reflect"
 )
 
 type ISession interface {
@@ -12,25 +15,94 @@ type ISession interface {
 	SendMsg(protocolbase.IMsg)
 	SendBytes([]byte)
 	IpStr() string
+	Listener() string
 	SetTag(interface{})
 	GetTag() interface{}
+	ErrCount() MsgErrCount
+	Attrs() *AttrStore
+	CodecMode() int
+}
+
+var (
+	ErrMsgHeader    = errors.New("Err MsgHeader")
+	ErrMsgCreateNil = errors.New("Err MsgCreateNil")
+	ErrMsgUnpack    = errors.New("Err MsgUnpack")
+)
+
+//MsgDecodeError 解包失败,Err是ErrMsgHeader或ErrMsgUnpack,Cause是解码器给出的位置和类型
+type MsgDecodeError struct {
+	Err   error
+	Cause error
+}
+
+func (err *MsgDecodeError) Error() string {
+	return err.Err.Error() + ": " + err.Cause.Error()
+}
+
+func (err *MsgDecodeError) Unwrap() []error {
+	return []error{err.Err, err.Cause}
+}
+
+//unpackMsg 解包失败时错误里带上消息名,消息自己的Unpack会带上字段名
+func unpackMsg(msg protocolbase.IMsg, id uint32, unpacker protocolbase.IUnpacker) error {
+	r := msg.Unpack(unpacker)
+	if r == 0 {
+		return nil
+	}
+	if desc := protocolbase.FindMsgDesc(id); desc != nil {
+		unpacker.InField(r, desc.Name)
+	} else {
+		unpacker.InField(r, reflect.TypeOf(msg).Elem().Name())
+	}
+	return unpackError(ErrMsgUnpack, unpacker)
+}
+
+//unpackError 解码器有详细原因就带上
+func unpackError(err error, unpacker protocolbase.IUnpacker) error {
+	if cause := unpacker.Err(); cause != nil {
+		return &MsgDecodeError{Err: err, Cause: cause}
+	}
+	return err
+}
+
+//MsgErrCount 会话收到的错误消息计数
+type MsgErrCount struct {
+	Unknown uint32
+	Decode  uint32
 }
 
 type sessionEvent struct {
 	event   int
 	msgdata []byte
 	ws      gnet.ISocket
+	session *Session
 }
 
 type msgProxy struct {
 	msgHandler func(ISession, protocolbase.IMsg, bool)
 	msgCreate  func() protocolbase.IMsg
+	worker     bool
 }
 
 type SessionMsgProxy struct {
 	msghanders    map[uint32]*msgProxy
 	fsessionOpen  func(ISession)
 	fSessionClose func(ISession)
+	fUnknownMsg   func(ISession, uint32, []byte)
+	fDecodeError  func(ISession, uint32, []byte, error)
+	fRawMsg       func(ISession, uint32, []byte) bool
+	debug         *MsgDebug
+	presence      *Presence
+}
+
+//UsePresence 会话关闭时自动从presence解绑,Start之前调用
+func (proxy *SessionMsgProxy) UsePresence(presence *Presence) {
+	proxy.presence = presence
+}
+
+//SetDebug 设置协议调试输出,Start之前调用,之后用MsgDebug开关
+func (proxy *SessionMsgProxy) SetDebug(debug *MsgDebug) {
+	proxy.debug = debug
 }
 
 func (proxy *SessionMsgProxy) FindMsgProxy(msgid uint32) *msgProxy {
@@ -44,6 +116,12 @@ func (proxy *SessionMsgProxy) RegIMsgHandler(msgid uint32, mh func(ISession, pro
 	proxy.msghanders[msgid] = &msgProxy{msgHandler: mh, msgCreate: mc}
 }
 
+//RegIMsgWorkerHandler 注册可以在工作协程执行的消息,SessionManager开启工作协程后才有效
+//同一个会话的消息还是按顺序执行,处理函数里不能访问主循环的数据
+func (proxy *SessionMsgProxy) RegIMsgWorkerHandler(msgid uint32, mh func(ISession, protocolbase.IMsg, bool), mc func() protocolbase.IMsg) {
+	proxy.msghanders[msgid] = &msgProxy{msgHandler: mh, msgCreate: mc, worker: true}
+}
+
 func (proxy *SessionMsgProxy) RegSessionOpen(f func(ISession)) {
 	proxy.fsessionOpen = f
 }
@@ -52,6 +130,101 @@ func (proxy *SessionMsgProxy) RegSessionClose(f func(ISession)) {
 	proxy.fSessionClose = f
 }
 
+//RegUnknownMsg 收到没注册的消息,参数是消息号和整个包
+func (proxy *SessionMsgProxy) RegUnknownMsg(f func(ISession, uint32, []byte)) {
+	proxy.fUnknownMsg = f
+}
+
+//RegDecodeError 消息解包失败,消息头都解不出来时消息号为0,用errors.As可以取到*msgpack.DecodeError
+func (proxy *SessionMsgProxy) RegDecodeError(f func(ISession, uint32, []byte, error)) {
+	proxy.fDecodeError = f
+}
+
+//RegRawMsg 解包之前先交给f,返回true表示已经处理了,网关转发用
+func (proxy *SessionMsgProxy) RegRawMsg(f func(ISession, uint32, []byte) bool) {
+	proxy.fRawMsg = f
+}
+
+//NotifyOpen 调用注册的连接回调,给自己管理会话的模块用
+func (proxy *SessionMsgProxy) NotifyOpen(session ISession) {
+	if proxy.fsessionOpen != nil {
+		proxy.fsessionOpen(session)
+	}
+}
+
+//NotifyClose 调用注册的关闭回调并清掉会话属性,给自己管理会话的模块用
+func (proxy *SessionMsgProxy) NotifyClose(session ISession) {
+	if proxy.fSessionClose != nil {
+		proxy.fSessionClose(session)
+	}
+	if proxy.presence != nil {
+		proxy.presence.UnbindAll(session)
+	}
+	session.Attrs().clear(session)
+}
+
+//Dispatch 解包整个消息并调用注册的处理函数,给自己管理会话的模块用
+func (proxy *SessionMsgProxy) Dispatch(session ISession, errcount *MsgErrCount, data []byte) bool {
+	unpacker := msgpack.PopUnPacker()
+	defer msgpack.PushUnPacker(unpacker)
+	unpacker.SetMode(session.CodecMode())
+	unpacker.Attatch(data)
+	r, id := unpacker.UnPackUInt32()
+	if r != 0 {
+		proxy.decodeError(session, errcount, 0, data, unpackError(ErrMsgHeader, unpacker))
+		return false
+	}
+	return proxy.handleIMsg(session, errcount, unpacker, id, data)
+}
+
+//handleIMsg 解包并调用处理函数,解包失败返回false
+func (proxy *SessionMsgProxy) handleIMsg(session ISession, errcount *MsgErrCount, unpacker protocolbase.IUnpacker, id uint32, data []byte) bool {
+	proxy.debug.output("recv", session.ID(), session.CodecMode(), data)
+	if proxy.fRawMsg != nil {
+		raw := false
+		com.SafeCall(func() {
+			raw = proxy.fRawMsg(session, id, data)
+		})
+		if raw {
+			return true
+		}
+	}
+	msgProxy := proxy.FindMsgProxy(id)
+	if msgProxy == nil {
+		errcount.Unknown++
+		if proxy.fUnknownMsg != nil {
+			com.SafeCall(func() {
+				proxy.fUnknownMsg(session, id, data)
+			})
+		}
+		return true
+	}
+	var err error
+	com.SafeCall(func() {
+		msg := msgProxy.msgCreate()
+		if msg == nil {
+			err = ErrMsgCreateNil
+			return
+		}
+		err = unpackMsg(msg, id, unpacker)
+		msgProxy.msgHandler(session, msg, err == nil)
+	})
+	if err != nil {
+		proxy.decodeError(session, errcount, id, data, err)
+		return false
+	}
+	return true
+}
+
+func (proxy *SessionMsgProxy) decodeError(session ISession, errcount *MsgErrCount, id uint32, data []byte, err error) {
+	errcount.Decode++
+	if proxy.fDecodeError != nil {
+		com.SafeCall(func() {
+			proxy.fDecodeError(session, id, data, err)
+		})
+	}
+}
+
 type SessionMsgQueue struct {
 	msgrec  *datastruct.SyncQueue
 	msghand *datastruct.Queue
@@ -63,12 +236,43 @@ func (queue *SessionMsgQueue) init() {
 }
 
 func (queue *SessionMsgQueue) copymsg() {
+	//上一批没处理完的先处理,不然交换会覆盖掉
+	if queue.msghand.Peek() != nil {
+		return
+	}
 	queue.msgrec.SwapQueue(queue.msghand)
 }
 
 type BaseSession struct {
-	ws  gnet.ISocket
-	tag interface{}
+	ws       gnet.ISocket
+	tag      interface{}
+	errcount MsgErrCount
+	attrs    AttrStore
+	mode     int
+}
+
+//CodecMode 消息的编码方式,msgpack.ModeLegacy或者msgpack.ModeSpec
+func (session *BaseSession) CodecMode() int {
+	return session.mode
+}
+
+//SetCodecMode 客户端在Start之前调用,服务器的会话按监听设置,不认识的编码方式返回false
+func (session *BaseSession) SetCodecMode(mode int) bool {
+	if !msgpack.ValidMode(mode) {
+		return false
+	}
+	session.mode = mode
+	return true
+}
+
+//Attrs 会话属性,用Attr和SetAttr访问
+func (session *BaseSession) Attrs() *AttrStore {
+	return &session.attrs
+}
+
+//ErrCount 收到的错误消息计数
+func (session *BaseSession) ErrCount() MsgErrCount {
+	return session.errcount
 }
 
 func (session *BaseSession) SetTag(tag interface{}) {
@@ -86,6 +290,8 @@ func (session *BaseSession) ID() uint64 {
 func (session *BaseSession) SendMsg(msg protocolbase.IMsg) {
 	packer := msgpack.PopPacker()
 	defer msgpack.PushPacker(packer)
+	packer.ClearBuffer()
+	packer.SetMode(session.mode)
 	msg.Pack(packer, true)
 	data := packer.GetBuffer()
 	session.SendBytes(data)
@@ -0,0 +1,93 @@
+package session
+
+import (
+	"g_server/framework/com"
+	"reflect"
+	"sync"
+)
+
+type attrValue struct {
+	value   interface{}
+	onclose func(ISession)
+}
+
+//AttrStore 会话属性,按值的类型区分,每个模块定义自己的类型就不会互相覆盖
+//会话关闭时自动清掉,设置了关闭回调的会先调用回调
+type AttrStore struct {
+	lock  sync.Mutex
+	attrs map[reflect.Type]*attrValue
+}
+
+func attrType[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (store *AttrStore) set(key reflect.Type, attr *attrValue) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.attrs == nil {
+		store.attrs = make(map[reflect.Type]*attrValue)
+	}
+	store.attrs[key] = attr
+}
+
+func (store *AttrStore) get(key reflect.Type) (interface{}, bool) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if attr, ok := store.attrs[key]; ok {
+		return attr.value, true
+	}
+	return nil, false
+}
+
+func (store *AttrStore) del(key reflect.Type) bool {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.attrs[key]; !ok {
+		return false
+	}
+	delete(store.attrs, key)
+	return true
+}
+
+//clear 会话关闭时调用,回调在锁外执行,回调里还能访问别的属性
+func (store *AttrStore) clear(session ISession) {
+	store.lock.Lock()
+	attrs := store.attrs
+	store.attrs = nil
+	store.lock.Unlock()
+	for _, attr := range attrs {
+		if attr.onclose != nil {
+			com.SafeCall(func() {
+				attr.onclose(session)
+			})
+		}
+	}
+}
+
+//Attr 取会话上类型为T的属性
+func Attr[T any](session ISession) (T, bool) {
+	value, ok := session.Attrs().get(attrType[T]())
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return value.(T), true
+}
+
+//SetAttr 设置会话上类型为T的属性,覆盖时不调用旧值的关闭回调
+func SetAttr[T any](session ISession, value T) {
+	session.Attrs().set(attrType[T](), &attrValue{value: value})
+}
+
+//SetAttrClose 设置属性并带上关闭回调,会话关闭时调用,DelAttr删掉的不调用
+func SetAttrClose[T any](session ISession, value T, onclose func(ISession, T)) {
+	session.Attrs().set(attrType[T](), &attrValue{value: value, onclose: func(s ISession) {
+		onclose(s, value)
+	}})
+}
+
+//DelAttr 删掉会话上类型为T的属性
+func DelAttr[T any](session ISession) bool {
+	return session.Attrs().del(attrType[T]())
+}
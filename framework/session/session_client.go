@@ -1,9 +1,14 @@
 package session
 
 import (
-	"g_server/framework/com"
+	"g_server/framework/crypto"
 	"g_server/framework/gnet"
+	"g_server/framework/log"
 	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,13 +19,81 @@ type SessionClient struct {
 	rcontime int32
 	state    int32
 	name     string
+
+	//连接池里的连接用池的注册表,池上后注册的回调也能生效
+	proxy *SessionMsgProxy
+
+	//服务器开启断线恢复时使用
+	resumetoken string
+	resumegrace time.Duration
+	recvseq     uint64
+	closetime   time.Time
+
+	rsahelp   *crypto.CryptoRsaHelp
+	securekey []byte
+	secure    *secureCipher
+	sendlock  sync.Mutex
+
+	//重连间隔从backoffmin开始翻倍,最多backoffmax,再加一半以内的随机值
+	backoffmin time.Duration
+	backoffmax time.Duration
+	fails      int32
+	stopped    int32
+
+	sendcount uint64
+	recvcount uint64
+
+	//被服务器踢掉的原因,noreconnect里的原因不再重连
+	kickcode    uint32
+	kicktext    string
+	noreconnect map[uint32]bool
+	fkick       func(ISession, uint32, string)
 }
 
 func (session *SessionClient) OnSocketMessage(ws gnet.ISocket, msg []byte) {
+	if session.rsahelp != nil {
+		if session.secure == nil {
+			//第一条是服务器的随机数,算出密钥后才算连上
+			secure, err := secureFinish(session.securekey, msg, session.mode)
+			if err != nil {
+				glog.LogConsole(glog.LogWarning, "secure finish fail", session.name, err)
+				ws.Close()
+				return
+			}
+			session.sendlock.Lock()
+			session.secure = secure
+			session.securekey = nil
+			session.sendlock.Unlock()
+			session.msgrec.Push(&sessionEvent{event: sessionEventOpen, ws: ws})
+			return
+		}
+		data, err := session.secure.open(msg)
+		if err != nil {
+			glog.LogConsole(glog.LogWarning, "secure open fail", session.name, err)
+			ws.Close()
+			return
+		}
+		msg = data
+	}
 	session.msgrec.Push(&sessionEvent{event: sessionEventMsg, ws: ws, msgdata: msg})
 }
 
 func (session *SessionClient) OnSocketOpen(ws gnet.ISocket) {
+	if session.rsahelp != nil {
+		//先把密钥发过去,等服务器回了随机数再通知连上
+		key, data, err := secureConnect(session.rsahelp, session.mode)
+		if err != nil {
+			glog.LogConsole(glog.LogError, "secure connect fail", session.name, err)
+			ws.Close()
+			return
+		}
+		session.sendlock.Lock()
+		session.secure = nil
+		session.securekey = key
+		ws.SendBit(data)
+		session.sendlock.Unlock()
+		return
+	}
 	session.msgrec.Push(&sessionEvent{event: sessionEventOpen, ws: ws})
 }
 
@@ -40,31 +113,35 @@ func (session *SessionClient) handleEvent() {
 		case sessionEventOpen:
 			{
 				session.state = 2
-				if session.fsessionOpen != nil {
-					session.fsessionOpen(session)
+				session.kickcode = 0
+				session.kicktext = ""
+				atomic.StoreUint64(&session.sendcount, 0)
+				session.recvcount = 0
+				//每次连上都先发恢复请求,没有凭证就是新会话
+				session.SendMsg(&sysMsgResume{token: session.resumetoken, seq: session.recvseq})
+				if session.resumetoken == "" {
+					session.msgproxy().NotifyOpen(session)
 				}
 			}
 		case sessionEventClose:
 			{
 				session.state = 0
 				session.reCon()
-				if session.fSessionClose != nil {
-					session.fSessionClose(session)
+				if session.resumetoken != "" {
+					//等待恢复,超时了再通知关闭
+					if session.closetime.IsZero() {
+						session.closetime = time.Now()
+					}
+					continue
 				}
+				session.msgproxy().NotifyClose(session)
 			}
 		case sessionEventMsg:
 			{
+				session.recvcount++
 				unpacker := msgpack.PopUnPacker()
-				unpacker.Attatch(event.msgdata)
-				if r, id := unpacker.UnPackUInt32(); r == 0 {
-					if msgProxy := session.FindMsgProxy(id); msgProxy != nil {
-						com.SafeCall(func() {
-							if msg := msgProxy.msgCreate(); msg != nil {
-								msgProxy.msgHandler(session, msg, msg.Unpack(unpacker) == 0)
-							}
-						})
-					}
-				}
+				unpacker.SetMode(session.mode)
+				session.dispatch(unpacker, event.msgdata)
 				msgpack.PushUnPacker(unpacker)
 			}
 		}
@@ -72,41 +149,212 @@ func (session *SessionClient) handleEvent() {
 
 }
 
+func (session *SessionClient) dispatch(unpacker protocolbase.IUnpacker, data []byte) {
+	unpacker.Attatch(data)
+	r, id := unpacker.UnPackUInt32()
+	if r != 0 {
+		session.msgproxy().decodeError(session, &session.errcount, 0, data, unpackError(ErrMsgHeader, unpacker))
+		return
+	}
+	if isSysMsg(id) {
+		session.handleSysMsg(unpacker, id)
+		return
+	}
+	session.msgproxy().handleIMsg(session, &session.errcount, unpacker, id, data)
+}
+
+func (session *SessionClient) handleSysMsg(unpacker protocolbase.IUnpacker, id uint32) {
+	switch id {
+	case SysMsgIdSeq:
+		msg := &sysMsgSeq{}
+		if msg.Unpack(unpacker) != 0 || msg.seq <= session.recvseq {
+			return
+		}
+		session.recvseq = msg.seq
+		session.dispatch(unpacker, msg.data)
+	case SysMsgIdResumeToken:
+		msg := &sysMsgResumeToken{}
+		if msg.Unpack(unpacker) == 0 {
+			session.resumetoken = msg.token
+			session.resumegrace = time.Duration(msg.grace) * time.Second
+			session.recvseq = 0
+		}
+	case SysMsgIdKick:
+		msg := &sysMsgKick{}
+		if msg.Unpack(unpacker) != 0 {
+			return
+		}
+		session.kickcode = msg.code
+		session.kicktext = msg.text
+		//被踢的会话不会再恢复
+		session.resumetoken = ""
+		session.recvseq = 0
+		if session.noreconnect[msg.code] {
+			atomic.StoreInt32(&session.stopped, 1)
+		}
+		if session.fkick != nil {
+			session.fkick(session, msg.code, msg.text)
+		}
+	case SysMsgIdResumeResult:
+		msg := &sysMsgResumeResult{}
+		if msg.Unpack(unpacker) != 0 {
+			return
+		}
+		session.closetime = time.Time{}
+		if !msg.ok {
+			//旧会话没了,按照断开再连上处理
+			session.resumetoken = ""
+			session.recvseq = 0
+			session.msgproxy().NotifyClose(session)
+			session.msgproxy().NotifyOpen(session)
+		}
+	}
+}
+
+//checkResume 断线太久服务器已经丢掉会话了
+func (session *SessionClient) checkResume() {
+	if session.closetime.IsZero() || time.Now().Sub(session.closetime) < session.resumegrace {
+		return
+	}
+	session.closetime = time.Time{}
+	session.resumetoken = ""
+	session.recvseq = 0
+	session.msgproxy().NotifyClose(session)
+}
+
 func (session *SessionClient) reCon() {
-	if session.state == 0 {
+	if session.state == 0 && atomic.LoadInt32(&session.stopped) == 0 {
 		session.state = 1
 		go func() {
-			for {
+			session.ws.SetWatcher(session)
+			delay := session.backoffmin
+			for atomic.LoadInt32(&session.stopped) == 0 {
 				if session.ws.Start() {
-					session.ws.SetWatcher(session)
+					atomic.StoreInt32(&session.fails, 0)
 					return
 				}
-				time.Sleep(time.Duration(session.rcontime) * time.Second)
+				atomic.AddInt32(&session.fails, 1)
+				time.Sleep(delay + time.Duration(rand.Int63n(int64(delay/2)+1)))
+				if delay *= 2; delay > session.backoffmax {
+					delay = session.backoffmax
+				}
 			}
 		}()
 	}
 }
 
+//RegKick 被服务器踢掉时调用,之后会收到关闭
+func (session *SessionClient) RegKick(f func(ISession, uint32, string)) {
+	session.fkick = f
+}
+
+//SetNoReconnect 被这些原因踢掉后不再重连,默认是封号和别处登录
+func (session *SessionClient) SetNoReconnect(codes ...uint32) {
+	session.noreconnect = make(map[uint32]bool)
+	for _, code := range codes {
+		session.noreconnect[code] = true
+	}
+}
+
+//KickReason 最后一次被踢的原因,连上后清空
+func (session *SessionClient) KickReason() (uint32, string) {
+	return session.kickcode, session.kicktext
+}
+
+//SetBackoff 设置重连间隔,Start之前调用,不能小于BackoffFloor
+func (session *SessionClient) SetBackoff(min time.Duration, max time.Duration) {
+	if min < BackoffFloor {
+		min = BackoffFloor
+	}
+	if max < min {
+		max = min
+	}
+	session.backoffmin = min
+	session.backoffmax = max
+}
+
+//Fails 连续连接失败次数,连上后清零
+func (session *SessionClient) Fails() int32 {
+	return atomic.LoadInt32(&session.fails)
+}
+
+//Pending 本次连上后发出的消息数减去收到的消息数,请求应答的连接可以用来估计积压
+func (session *SessionClient) Pending() uint64 {
+	send := atomic.LoadUint64(&session.sendcount)
+	if send <= session.recvcount {
+		return 0
+	}
+	return send - session.recvcount
+}
+
 func (session *SessionClient) Valid() bool {
 	return session.state == 2
 }
 
+func (session *SessionClient) SendMsg(msg protocolbase.IMsg) {
+	packer := msgpack.PopPacker()
+	defer msgpack.PushPacker(packer)
+	packer.ClearBuffer()
+	packer.SetMode(session.mode)
+	msg.Pack(packer, true)
+	session.SendBytes(packer.GetBuffer())
+}
+
+func (session *SessionClient) SendBytes(data []byte) {
+	session.msgproxy().debug.output("send", session.ID(), session.mode, data)
+	session.sendlock.Lock()
+	defer session.sendlock.Unlock()
+	if session.secure != nil {
+		data = session.secure.seal(data)
+	} else if session.rsahelp != nil {
+		//握手没完成不能发明文
+		return
+	}
+	atomic.AddUint64(&session.sendcount, 1)
+	session.ws.SendBit(data)
+}
+
+//EnableSecure 开启加密会话,需要服务器的公钥,Start之前调用
+func (session *SessionClient) EnableSecure(rsahelp *crypto.CryptoRsaHelp) {
+	session.rsahelp = rsahelp
+}
+
 func (session *SessionClient) Run() {
 	session.handleEvent()
+	session.checkResume()
 }
 
 func (session *SessionClient) Start() bool {
 	session.init()
+	atomic.StoreInt32(&session.stopped, 0)
 	session.reCon()
 	return true
 }
 
+func (session *SessionClient) msgproxy() *SessionMsgProxy {
+	if session.proxy != nil {
+		return session.proxy
+	}
+	return &session.SessionMsgProxy
+}
+
+//opened 用户收到了打开还没收到关闭,断线等恢复的也算
+func (session *SessionClient) opened() bool {
+	return session.state == 2 || !session.closetime.IsZero()
+}
+
 func (session *SessionClient) Stop() bool {
+	atomic.StoreInt32(&session.stopped, 1)
 	session.ws.SetWatcher(nil)
 	session.ws.Close()
 	return true
 }
 
+//Listener 客户端没有监听
+func (session *SessionClient) Listener() string {
+	return ""
+}
+
 func (session *SessionClient) Name() string {
 	return session.name
 }
@@ -0,0 +1,20 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+//TestBackoffFloor 重连间隔不能设成0
+func TestBackoffFloor(t *testing.T) {
+	client := NewWsSessionClient("test", "ws://127.0.0.1:1/", 1, 1024)
+	client.SetBackoff(0, 0)
+	if client.backoffmin != BackoffFloor || client.backoffmax != BackoffFloor {
+		t.Fatalf("client backoff %v %v", client.backoffmin, client.backoffmax)
+	}
+	pool := NewWsSessionClientPool("test", nil, 1, 1, 1024, PoolRoundRobin)
+	pool.SetBackoff(-time.Second, time.Millisecond)
+	if pool.backoffmin != BackoffFloor || pool.backoffmax != BackoffFloor {
+		t.Fatalf("pool backoff %v %v", pool.backoffmin, pool.backoffmax)
+	}
+}
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"g_server/framework/log"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"sync"
+)
+
+//MsgDebug 协议调试输出,把收发的消息打印成消息名加各个字段的JSON数组,运行中可以随时开关和改过滤
+//生成的消息字段不导出,直接按msgpack解出字段值,消息名用protocolbase.RegMsgs注册,没有注册打印消息号
+type MsgDebug struct {
+	lock     sync.RWMutex
+	enable   bool
+	sessions map[uint64]bool
+	msgids   map[uint32]bool
+}
+
+//Enable 打开或关闭
+func (debug *MsgDebug) Enable(enable bool) {
+	debug.lock.Lock()
+	defer debug.lock.Unlock()
+	debug.enable = enable
+}
+
+//FilterSession 只输出这些会话,不传表示全部
+func (debug *MsgDebug) FilterSession(ids ...uint64) {
+	debug.lock.Lock()
+	defer debug.lock.Unlock()
+	debug.sessions = make(map[uint64]bool)
+	for _, id := range ids {
+		debug.sessions[id] = true
+	}
+}
+
+//FilterMsg 只输出这些消息,不传表示全部
+func (debug *MsgDebug) FilterMsg(ids ...uint32) {
+	debug.lock.Lock()
+	defer debug.lock.Unlock()
+	debug.msgids = make(map[uint32]bool)
+	for _, id := range ids {
+		debug.msgids[id] = true
+	}
+}
+
+func (debug *MsgDebug) enabled() bool {
+	debug.lock.RLock()
+	defer debug.lock.RUnlock()
+	return debug.enable
+}
+
+func (debug *MsgDebug) match(sid uint64, msgid uint32) bool {
+	debug.lock.RLock()
+	defer debug.lock.RUnlock()
+	if len(debug.sessions) > 0 && !debug.sessions[sid] {
+		return false
+	}
+	if len(debug.msgids) > 0 && !debug.msgids[msgid] {
+		return false
+	}
+	return true
+}
+
+//output dir是recv或者send,data是带消息号的整个消息
+func (debug *MsgDebug) output(dir string, sid uint64, mode int, data []byte) {
+	if debug == nil || !debug.enabled() {
+		return
+	}
+	unpacker := msgpack.PopUnPacker()
+	defer msgpack.PushUnPacker(unpacker)
+	unpacker.SetMode(mode)
+	unpacker.Attatch(data)
+	r, msgid := unpacker.UnPackUInt32()
+	if r != 0 || isSysMsg(msgid) || !debug.match(sid, msgid) {
+		return
+	}
+	name, text := debugText(msgid, mode, unpacker, data)
+	glog.LogConsole(glog.LogForce, "msgdebug", dir, sid, name, text)
+}
+
+//debugText 消息名和字段值,unpacker已经读过消息号
+func debugText(msgid uint32, mode int, unpacker *msgpack.UnPacker, data []byte) (string, string) {
+	name := fmt.Sprint(msgid)
+	if desc := protocolbase.FindMsgDesc(msgid); desc != nil {
+		name = desc.Name
+		//注册了的先按消息解一遍,对不上的也打印出来方便查
+		if desc.Create != nil {
+			if msg := desc.Create(); msg != nil && msg.Unpack(unpacker) != 0 {
+				name += "(unpack fail)"
+			}
+		}
+	}
+	values, err := msgpack.DecodeAllMode(data, mode)
+	if err != nil {
+		return name, fmt.Sprint("len ", len(data), " ", err)
+	}
+	text, err := json.Marshal(msgpack.JSONValue(values[1:]))
+	if err != nil {
+		return name, err.Error()
+	}
+	return name, string(text)
+}
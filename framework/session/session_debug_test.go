@@ -0,0 +1,32 @@
+package session
+
+import (
+	"fmt"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"testing"
+)
+
+//TestDebugText 生成的消息字段不导出,也要打印出字段值
+func TestDebugText(t *testing.T) {
+	protocolbase.RegMsgs(&testMsg{})
+	for _, mode := range []int{msgpack.ModeLegacy, msgpack.ModeSpec} {
+		cases := []struct {
+			data []byte
+			name string
+			text string
+		}{
+			{packMsg(&testMsg{value: 42}, mode), "testMsg", "[42]"},
+			{packMsg(&sysMsgKick{code: 3, text: "dup"}, mode), fmt.Sprint(SysMsgIdKick), `[3,"dup"]`},
+		}
+		for _, c := range cases {
+			unpacker := msgpack.NewUnPacker()
+			unpacker.SetMode(mode)
+			unpacker.Attatch(c.data)
+			_, msgid := unpacker.UnPackUInt32()
+			if name, text := debugText(msgid, mode, unpacker, c.data); name != c.name || text != c.text {
+				t.Fatalf("mode %d got %s %s, want %s %s", mode, name, text, c.name, c.text)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package session
+
+import (
+	"errors"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"testing"
+)
+
+//TestDecodeErrorField 解包失败的错误里有消息名和字段名,主循环和工作协程一样
+func TestDecodeErrorField(t *testing.T) {
+	for _, worker := range []bool{false, true} {
+		var field string
+		manager, server := testManager(t, func(manager *SessionManager) {
+			handler := func(s ISession, msg protocolbase.IMsg, ok bool) {}
+			create := func() protocolbase.IMsg { return &testMsg{} }
+			if worker {
+				manager.RegIMsgWorkerHandler(testMsgId, handler, create)
+			} else {
+				manager.RegIMsgHandler(testMsgId, handler, create)
+			}
+			manager.RegDecodeError(func(s ISession, id uint32, data []byte, err error) {
+				var derr *msgpack.DecodeError
+				if errors.As(err, &derr) {
+					field = derr.Field
+				}
+			})
+		})
+		ws := server.Dial("mem")
+		manager.Run()
+		packer := msgpack.NewPacker()
+		packer.PackUInt32(testMsgId)
+		packer.PackString("not a number")
+		ws.Recv(packer.GetBuffer())
+		runUntil(t, manager, func() bool { return field != "" })
+		if field != "testMsg.value" {
+			t.Fatalf("worker %v field %q", worker, field)
+		}
+	}
+}
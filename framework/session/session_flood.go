@@ -0,0 +1,108 @@
+package session
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FloodActionDrop  = 0 //丢掉超出的消息
+	FloodActionDelay = 1 //留到后面再处理
+	FloodActionKick  = 2 //踢掉
+
+	FloodReasonMsgRate  = 1
+	FloodReasonByteRate = 2
+	FloodReasonPending  = 3
+)
+
+//FloodLimit 单个会话的上行流量限制,0表示不限制
+type FloodLimit struct {
+	MsgPerSec   uint32
+	BytesPerSec uint32
+	MaxPending  uint32
+	Action      int
+	OnViolate   func(session ISession, reason int, msgid uint32)
+	costs       map[uint32]uint32
+}
+
+//SetMsgCost 设置消息的权重,默认是1
+func (limit *FloodLimit) SetMsgCost(msgid uint32, cost uint32) *FloodLimit {
+	if limit.costs == nil {
+		limit.costs = make(map[uint32]uint32)
+	}
+	limit.costs[msgid] = cost
+	return limit
+}
+
+func (limit *FloodLimit) msgCost(msgid uint32) float64 {
+	if cost, ok := limit.costs[msgid]; ok {
+		return float64(cost)
+	}
+	return 1
+}
+
+//floodBucket 令牌桶,最多攒1秒的量
+type floodBucket struct {
+	msgtoken  float64
+	bytetoken float64
+	lasttime  time.Time
+	delayed   bool
+	pending   int32
+	overflow  int32
+}
+
+func (bucket *floodBucket) refill(limit *FloodLimit, now time.Time) {
+	if bucket.lasttime.IsZero() {
+		bucket.msgtoken, bucket.bytetoken, bucket.lasttime = float64(limit.MsgPerSec), float64(limit.BytesPerSec), now
+		return
+	}
+	elapsed := now.Sub(bucket.lasttime).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	bucket.lasttime = now
+	bucket.msgtoken += elapsed * float64(limit.MsgPerSec)
+	if bucket.msgtoken > float64(limit.MsgPerSec) {
+		bucket.msgtoken = float64(limit.MsgPerSec)
+	}
+	bucket.bytetoken += elapsed * float64(limit.BytesPerSec)
+	if bucket.bytetoken > float64(limit.BytesPerSec) {
+		bucket.bytetoken = float64(limit.BytesPerSec)
+	}
+}
+
+//take 取令牌,返回0表示通过,否则是超出的原因
+func (bucket *floodBucket) take(limit *FloodLimit, now time.Time, msgid uint32, size int) int {
+	bucket.refill(limit, now)
+	//单条超过1秒的量就要求桶是满的,不然永远过不去
+	cost, bytes := limit.msgCost(msgid), float64(size)
+	if limit.MsgPerSec > 0 && bucket.msgtoken < math.Min(cost, float64(limit.MsgPerSec)) {
+		return FloodReasonMsgRate
+	}
+	if limit.BytesPerSec > 0 && bucket.bytetoken < math.Min(bytes, float64(limit.BytesPerSec)) {
+		return FloodReasonByteRate
+	}
+	bucket.msgtoken -= cost
+	bucket.bytetoken -= bytes
+	return 0
+}
+
+//push 网络协程调用,队列满了返回false
+func (bucket *floodBucket) push(limit *FloodLimit) bool {
+	if limit != nil && limit.MaxPending > 0 && atomic.LoadInt32(&bucket.pending) >= int32(limit.MaxPending) {
+		atomic.AddInt32(&bucket.overflow, 1)
+		return false
+	}
+	atomic.AddInt32(&bucket.pending, 1)
+	return true
+}
+
+func (bucket *floodBucket) pop() {
+	atomic.AddInt32(&bucket.pending, -1)
+}
+
+//takeOverflow 取出队列满丢掉的消息数
+func (bucket *floodBucket) takeOverflow() int32 {
+	return atomic.SwapInt32(&bucket.overflow, 0)
+}
@@ -0,0 +1,27 @@
+package session
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+//TestFloodMalformed 消息号都解不出来的包也要算流量
+func TestFloodMalformed(t *testing.T) {
+	var violate int32
+	manager, server := testManager(t, func(manager *SessionManager) {
+		manager.SetFloodLimit(&FloodLimit{MsgPerSec: 3, Action: FloodActionDrop, OnViolate: func(s ISession, reason int, msgid uint32) {
+			if reason == FloodReasonMsgRate {
+				atomic.AddInt32(&violate, 1)
+			}
+		}})
+	})
+	ws := server.Dial("mem")
+	manager.Run()
+	for i := 0; i < 20; i++ {
+		ws.Recv([]byte{0xc1})
+	}
+	manager.Run()
+	if n := atomic.LoadInt32(&violate); n < 10 {
+		t.Fatalf("only %d of 20 malformed packets over the limit", n)
+	}
+}
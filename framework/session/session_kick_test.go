@@ -0,0 +1,21 @@
+package session
+
+import (
+	"g_server/framework/gnet"
+	"testing"
+)
+
+//TestKickWhereNil where传nil踢掉全部,不能panic
+func TestKickWhereNil(t *testing.T) {
+	manager, server := testManager(t, func(manager *SessionManager) {})
+	sockets := []*gnet.MemSocket{server.Dial("a"), server.Dial("b")}
+	runUntil(t, manager, func() bool { return manager.Count() == 2 })
+	if count := manager.KickWhere(nil, KickCodeMaintain, "maintain"); count != 2 {
+		t.Fatalf("kicked %d", count)
+	}
+	for _, ws := range sockets {
+		if code, text := ws.CloseCode(); code != uint16(kickCloseCodeBase+KickCodeMaintain) || text != "maintain" {
+			t.Fatalf("close code %d %q", code, text)
+		}
+	}
+}
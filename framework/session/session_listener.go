@@ -0,0 +1,17 @@
+package session
+
+import (
+	"g_server/framework/gnet"
+)
+
+//sessionListener 一个监听,多个监听共用一个SessionManager
+type sessionListener struct {
+	name    string
+	server  gnet.IServer
+	manager *SessionManager
+	mode    int
+}
+
+func (listener *sessionListener) OnSocketAccept(ws gnet.ISocket) {
+	listener.manager.accept(ws, listener)
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"g_server/framework/gnet"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"testing"
+)
+
+//TestListenerTcp 同一个管理器上ws之外的tcp监听,会话ID共用,能看出从哪个监听来的
+func TestListenerTcp(t *testing.T) {
+	tcp := gnet.NewTcpServer("127.0.0.1:0", 1<<16)
+	listeners := make(map[uint64]string)
+	manager, server := testManager(t, func(manager *SessionManager) {
+		manager.AddListener("tcp", tcp)
+		manager.RegIMsgHandler(testMsgId, func(s ISession, msg protocolbase.IMsg, ok bool) {
+			if ok {
+				listeners[s.ID()] = s.Listener()
+				s.SendMsg(msg)
+			}
+		}, func() protocolbase.IMsg { return &testMsg{} })
+	})
+	ws := server.Dial("mem")
+	ws.Recv(packMsg(&testMsg{value: 1}, msgpack.ModeLegacy))
+
+	replies := make(chan []byte, 1)
+	client := gnet.NewTcpClient(tcp.Addr(), 1<<16)
+	client.SetWatcher(&testSocketWatcher{msgs: replies})
+	if !client.Start() {
+		t.Fatal("dial fail")
+	}
+	defer client.Close()
+	client.SendBit(packMsg(&testMsg{value: 2}, msgpack.ModeLegacy))
+	var reply []byte
+	runUntil(t, manager, func() bool {
+		select {
+		case reply = <-replies:
+		default:
+		}
+		return reply != nil && len(listeners) == 2
+	})
+	unpacker := msgpack.NewUnPacker()
+	unpacker.Attatch(reply)
+	msg := &testMsg{}
+	if r, id := unpacker.UnPackUInt32(); r != 0 || id != testMsgId || msg.Unpack(unpacker) != 0 || msg.value != 2 {
+		t.Fatalf("bad reply %v", reply)
+	}
+	names := make(map[string]bool)
+	for _, name := range listeners {
+		names[name] = true
+	}
+	if !names["mem"] || !names["tcp"] {
+		t.Fatalf("listeners %v", listeners)
+	}
+}
+
+type testSocketWatcher struct {
+	msgs chan []byte
+}
+
+func (watcher *testSocketWatcher) OnSocketOpen(ws gnet.ISocket) {
+}
+
+func (watcher *testSocketWatcher) OnSocketClose(ws gnet.ISocket) {
+}
+
+func (watcher *testSocketWatcher) OnSocketMessage(ws gnet.ISocket, data []byte) {
+	watcher.msgs <- data
+}
@@ -1,29 +1,78 @@
 package session
 
 import (
+	"encoding/binary"
 	"g_server/framework/com"
+	"g_server/framework/crypto"
 	"g_server/framework/gnet"
+	"g_server/framework/log"
 	"g_server/framework/msgpack"
 	"g_server/framework/protocolbase"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Session struct {
 	BaseSession
 	SessionMsgQueue
-	manager *SessionManager
-	skip    bool
+	manager  *SessionManager
+	listener *sessionListener
+	id       uint64
+	skip     bool
+	resume   *sessionResume
+	bucket   floodBucket
+	inflight int
+	closing  bool
+	pendtime time.Time
+	sendlock sync.Mutex
+	secure   *secureCipher
 }
 
 func (session *Session) OnSocketOpen(ws gnet.ISocket) {
-	session.manager.msgrec.Push(&sessionEvent{event: sessionEventOpen, ws: ws})
+	if session.manager.rsahelp != nil {
+		//加密会话等收到密钥再通知,先记下来限时和算连接数
+		session.manager.msgrec.Push(&sessionEvent{event: sessionEventShake, ws: ws, session: session})
+		return
+	}
+	session.manager.msgrec.Push(&sessionEvent{event: sessionEventOpen, ws: ws, session: session})
 }
 
 func (session *Session) OnSocketClose(ws gnet.ISocket) {
-	session.ws.SetWatcher(nil)
-	session.manager.msgrec.Push(&sessionEvent{event: sessionEventClose, ws: ws})
+	ws.SetWatcher(nil)
+	if rec := session.manager.recorder; rec != nil {
+		rec.write(RecordClose, session.manager.now(), session.id, 0, nil)
+	}
+	session.manager.msgrec.Push(&sessionEvent{event: sessionEventClose, ws: ws, session: session})
 }
 
 func (session *Session) OnSocketMessage(ws gnet.ISocket, msg []byte) {
+	if session.manager.rsahelp != nil {
+		if session.secure == nil {
+			secure, reply, err := secureAccept(session.manager.rsahelp, msg, session.mode)
+			if err != nil {
+				glog.LogConsole(glog.LogWarning, "secure accept fail", ws.RemoteAddr(), err)
+				ws.Close()
+				return
+			}
+			session.sendlock.Lock()
+			ws.SendBit(reply)
+			session.secure = secure
+			session.sendlock.Unlock()
+			session.manager.msgrec.Push(&sessionEvent{event: sessionEventOpen, ws: ws, session: session})
+			return
+		}
+		data, err := session.secure.open(msg)
+		if err != nil {
+			glog.LogConsole(glog.LogWarning, "secure open fail", ws.RemoteAddr(), err)
+			ws.Close()
+			return
+		}
+		msg = data
+	}
+	if rec := session.manager.recorder; rec != nil {
+		rec.writeMsg(RecordIn, session.manager.now(), session.id, session.mode, msg)
+	}
 	if session.manager.hook != nil {
 		skip := false
 		com.SafeCall(func() {
@@ -32,61 +81,498 @@ func (session *Session) OnSocketMessage(ws gnet.ISocket, msg []byte) {
 		if skip {
 			return
 		}
-		session.msgrec.Push(msg)
-	} else {
+	}
+	if session.bucket.push(session.manager.flood) {
 		session.msgrec.Push(msg)
 	}
+}
 
+func (session *Session) popmsg() interface{} {
+	ibyte := session.msghand.Pop()
+	if ibyte != nil {
+		session.bucket.pop()
+	}
+	return ibyte
 }
 
-func (session *Session) handleMsg(unpacker protocolbase.IUnpacker) {
+func (session *Session) handleMsg(unpacker *msgpack.UnPacker) {
+	unpacker.SetMode(session.mode)
 	session.copymsg()
+	limit := session.manager.flood
+	if limit != nil {
+		if overflow := session.bucket.takeOverflow(); overflow > 0 {
+			if session.manager.floodViolate(session, FloodReasonPending, 0) {
+				return
+			}
+		}
+	}
+	now := session.manager.now()
 	for {
-		ibyte := session.msghand.Pop()
+		ibyte := session.msghand.Peek()
 		if ibyte == nil {
 			break
 		}
 		if session.skip {
+			session.popmsg()
 			continue
 		}
-		unpacker.Attatch(ibyte.([]byte))
-		if r, id := unpacker.UnPackUInt32(); r == 0 {
-			if msgProxy := session.manager.FindMsgProxy(id); msgProxy != nil {
-				com.SafeCall(func() {
-					if msg := msgProxy.msgCreate(); msg != nil {
-						msgProxy.msgHandler(session, msg, msg.Unpack(unpacker) == 0)
+		data := ibyte.([]byte)
+		unpacker.Attatch(data)
+		r, id := unpacker.UnPackUInt32()
+		worker := session.manager.workerProxy(r, id)
+		if worker == nil && session.inflight > 0 {
+			//等工作协程把前面的消息处理完
+			return
+		}
+		if worker != nil && session.manager.worker.busy(session) {
+			//工作协程排满了,这个会话的消息留在队列里下次再投递,不卡主循环
+			return
+		}
+		//消息号解不出来的坏包也要扣,不然能绕过限制
+		if limit != nil {
+			if reason := session.bucket.take(limit, now, id, len(data)); reason != 0 {
+				if limit.Action == FloodActionDelay {
+					//同一次积压只通知一次
+					if !session.bucket.delayed {
+						session.bucket.delayed = true
+						session.manager.floodViolate(session, reason, id)
 					}
-				})
+					return
+				}
+				session.popmsg()
+				if session.manager.floodViolate(session, reason, id) {
+					return
+				}
+				continue
 			}
 		}
+		session.bucket.delayed = false
+		session.popmsg()
+		if worker != nil {
+			session.manager.debug.output("recv", session.id, session.mode, data)
+			session.inflight++
+			session.manager.worker.post(&workerJob{session: session, proxy: worker, id: id, data: data})
+			continue
+		}
+		if !session.handleIMsg(unpacker, r, id, data) {
+			return
+		}
+	}
+}
+
+func (session *Session) dispatch(unpacker *msgpack.UnPacker, data []byte) {
+	unpacker.SetMode(session.mode)
+	unpacker.Attatch(data)
+	r, id := unpacker.UnPackUInt32()
+	session.handleIMsg(unpacker, r, id, data)
+}
+
+//handleIMsg r为解消息头的结果,错误太多被踢了返回false
+func (session *Session) handleIMsg(unpacker protocolbase.IUnpacker, r int, id uint32, data []byte) bool {
+	manager := session.manager
+	if r != 0 {
+		manager.decodeError(session, &session.errcount, 0, data, unpackError(ErrMsgHeader, unpacker))
+	} else if isSysMsg(id) {
+		return true
+	} else if manager.handleIMsg(session, &session.errcount, unpacker, id, data) {
+		return true
+	}
+	return !session.checkMalformed()
+}
+
+//checkMalformed 错误太多就踢掉
+func (session *Session) checkMalformed() bool {
+	manager := session.manager
+	if manager.maxmalformed > 0 && session.errcount.Decode >= manager.maxmalformed {
+		manager.KickWithReason(session.ID(), KickCodeMalformed, "malformed")
+		session.skip = true
+		return true
 	}
+	return false
 }
 
 func (session *Session) SkipMsg(skip bool) {
 	session.skip = skip
 }
 
+//ID 断线恢复后连接会变,所以用第一次连接的ID
+func (session *Session) ID() uint64 {
+	return session.id
+}
+
+//Listener 从哪个监听连上来的
+func (session *Session) Listener() string {
+	return session.listener.name
+}
+
+func (session *Session) SendMsg(msg protocolbase.IMsg) {
+	packer := msgpack.PopPacker()
+	defer msgpack.PushPacker(packer)
+	packer.ClearBuffer()
+	packer.SetMode(session.mode)
+	msg.Pack(packer, true)
+	session.SendBytes(packer.GetBuffer())
+}
+
+//SendBytes 开启恢复的话断线期间也会缓存起来
+func (session *Session) SendBytes(data []byte) {
+	//工作协程也会发消息
+	session.sendlock.Lock()
+	defer session.sendlock.Unlock()
+	if rec := session.manager.recorder; rec != nil {
+		rec.writeMsg(RecordOut, session.manager.now(), session.id, session.mode, data)
+	}
+	session.manager.debug.output("send", session.id, session.mode, data)
+	if session.resume != nil {
+		data = session.resume.wrap(data, session.mode)
+	}
+	session.send(data)
+}
+
+//sendSysMsg 框架消息不需要恢复
+func (session *Session) sendSysMsg(msg protocolbase.IMsg) {
+	session.sendlock.Lock()
+	defer session.sendlock.Unlock()
+	session.send(packMsg(msg, session.mode))
+}
+
+//send 加密后发送,调用前要加锁
+func (session *Session) send(data []byte) {
+	if session.secure != nil {
+		data = session.secure.seal(data)
+	}
+	session.ws.SendBit(data)
+}
+
 type SessionManager struct {
 	SessionMsgProxy
 	SessionMsgQueue
-	server     gnet.IServer
-	ssmap      map[uint64]*Session
-	maxsession uint32
-	name       string
-	hook       func(gnet.ISocket, []byte) bool
+	listeners    []*sessionListener
+	sessionid    uint64
+	ssmap        map[uint64]*Session
+	pendmap      map[uint64]*Session
+	shakemap     map[uint64]*Session
+	resumemap    map[string]*Session
+	resumegrace  time.Duration
+	resumesize   int
+	pendtimeout  time.Duration
+	flood        *FloodLimit
+	maxmalformed uint32
+	limits       *msgpack.DecodeLimits
+	rsahelp      *crypto.CryptoRsaHelp
+	workernum    int
+	worker       sessionWorker
+	maxsession   uint32
+	recorder     *SessionRecorder
+	clock        func() time.Time
+	name         string
+	hook         func(gnet.ISocket, []byte) bool
 }
 
-func (manager *SessionManager) OnSocketAccept(ws gnet.ISocket) {
-	session := &Session{BaseSession: BaseSession{ws: ws}, manager: manager, skip: false}
+//accept 多个监听的协程都会调用,ID由manager统一分配
+func (manager *SessionManager) accept(ws gnet.ISocket, listener *sessionListener) {
+	session := &Session{BaseSession: BaseSession{ws: ws, mode: listener.mode}, manager: manager, listener: listener, id: atomic.AddUint64(&manager.sessionid, 1), skip: false}
 	session.init()
+	if manager.recorder != nil {
+		manager.recorder.write(RecordOpen, manager.now(), session.id, 0, []byte(ws.RemoteAddr()))
+	}
 	ws.SetWatcher(session)
 }
 
+//EnableRecord 记录所有会话收发的消息,Start之前调用,Stop之后由调用方关闭rec
+func (manager *SessionManager) EnableRecord(rec *SessionRecorder) {
+	manager.recorder = rec
+}
+
+//SetClock 替换时钟,回放时用记录里的时间
+func (manager *SessionManager) SetClock(clock func() time.Time) {
+	manager.clock = clock
+}
+
+func (manager *SessionManager) now() time.Time {
+	if manager.clock != nil {
+		return manager.clock()
+	}
+	return time.Now()
+}
+
+//AddListener 加一个监听,Start之前调用,name不能重复
+func (manager *SessionManager) AddListener(name string, server gnet.IServer) bool {
+	for _, listener := range manager.listeners {
+		if listener.name == name {
+			return false
+		}
+	}
+	manager.listeners = append(manager.listeners, &sessionListener{name: name, server: server, manager: manager})
+	return true
+}
+
+//SetListenerMode 这个监听上的会话用的编码方式,新旧客户端可以连不同的监听,Start之前调用
+func (manager *SessionManager) SetListenerMode(name string, mode int) bool {
+	if !msgpack.ValidMode(mode) {
+		return false
+	}
+	for _, listener := range manager.listeners {
+		if listener.name == name {
+			listener.mode = mode
+			return true
+		}
+	}
+	return false
+}
+
 func (manager *SessionManager) getSession(id uint64) *Session {
 	session, _ := manager.ssmap[id]
 	return session
 }
 
+//EnableResume 开启断线恢复,Start之前调用,grace为保留时间,buffsize为缓存的下行消息条数
+func (manager *SessionManager) EnableResume(grace time.Duration, buffsize int) {
+	manager.resumegrace = grace
+	manager.resumesize = buffsize
+}
+
+//SetPendingTimeout 开启恢复或加密后新连接多久不发第一条消息就断开,默认DefaultPendingTimeout
+func (manager *SessionManager) SetPendingTimeout(timeout time.Duration) {
+	manager.pendtimeout = timeout
+}
+
+//SetFloodLimit 设置每个会话的上行限制,Start之前调用
+func (manager *SessionManager) SetFloodLimit(limit *FloodLimit) {
+	manager.flood = limit
+}
+
+//floodViolate 超出限制,返回true表示会话被踢掉了
+func (manager *SessionManager) floodViolate(session *Session, reason int, msgid uint32) bool {
+	if manager.flood.OnViolate != nil {
+		com.SafeCall(func() {
+			manager.flood.OnViolate(session, reason, msgid)
+		})
+	}
+	if manager.flood.Action == FloodActionKick {
+		manager.KickWithReason(session.ID(), KickCodeFlood, "flood")
+		session.skip = true
+		return true
+	}
+	return false
+}
+
+//SetMaxMalformed 解包失败n次就踢掉,0表示不踢
+func (manager *SessionManager) SetMaxMalformed(n uint32) {
+	manager.maxmalformed = n
+}
+
+//SetDecodeLimits 解客户端消息时的限制,不设置用msgpack的默认限制,Start之前调用
+func (manager *SessionManager) SetDecodeLimits(limits msgpack.DecodeLimits) {
+	manager.limits = &limits
+}
+
+//popUnPacker 取一个带上解码限制的UnPacker
+func (manager *SessionManager) popUnPacker() *msgpack.UnPacker {
+	unpacker := msgpack.PopUnPacker()
+	if manager.limits != nil {
+		unpacker.SetLimits(*manager.limits)
+	}
+	return unpacker
+}
+
+//EnableSecure 开启加密会话,需要私钥,Start之前调用
+//客户端连上后第一条消息是公钥加密的AES密钥,之后所有消息都用AES-GCM加密
+func (manager *SessionManager) EnableSecure(rsahelp *crypto.CryptoRsaHelp) {
+	manager.rsahelp = rsahelp
+}
+
+//EnableWorker 开启num个工作协程处理RegIMsgWorkerHandler注册的消息,Start之前调用
+func (manager *SessionManager) EnableWorker(num int) {
+	manager.workernum = num
+}
+
+//workerProxy 返回需要放到工作协程的消息
+func (manager *SessionManager) workerProxy(r int, id uint32) *msgProxy {
+	if manager.workernum <= 0 || r != 0 {
+		return nil
+	}
+	if msgProxy := manager.FindMsgProxy(id); msgProxy != nil && msgProxy.worker {
+		return msgProxy
+	}
+	return nil
+}
+
+//handleWorkerDone 工作协程执行完的消息回到主循环做统计
+func (manager *SessionManager) handleWorkerDone() {
+	for {
+		job := manager.worker.popDone()
+		if job == nil {
+			return
+		}
+		session := job.session
+		session.inflight--
+		if session.closing {
+			//已经关闭的会话等最后一个任务执行完再通知
+			if session.inflight == 0 {
+				manager.NotifyClose(session)
+			}
+			continue
+		}
+		if job.err != nil {
+			manager.decodeError(session, &session.errcount, job.id, job.data, job.err)
+			session.checkMalformed()
+		}
+	}
+}
+
+func (manager *SessionManager) resumeEnable() bool {
+	return manager.resumegrace > 0
+}
+
+func (manager *SessionManager) openSession(session *Session) {
+	manager.ssmap[session.ID()] = session
+	if manager.resumeEnable() {
+		//没有凭证的会话断线就关闭,和没开恢复一样
+		if resume, err := newSessionResume(manager.resumesize); err != nil {
+			glog.LogConsole(glog.LogError, "resume token fail", session.ID(), err)
+		} else {
+			session.resume = resume
+			manager.resumemap[resume.token] = session
+			session.sendSysMsg(&sysMsgResumeToken{token: resume.token, grace: uint32(manager.resumegrace / time.Second)})
+		}
+	}
+	if manager.fsessionOpen != nil {
+		manager.fsessionOpen(session)
+	}
+}
+
+func (manager *SessionManager) closeSession(session *Session) {
+	delete(manager.ssmap, session.ID())
+	if session.resume != nil {
+		delete(manager.resumemap, session.resume.token)
+		//工作协程发消息时会读resume
+		session.sendlock.Lock()
+		session.resume = nil
+		session.sendlock.Unlock()
+	}
+	if session.inflight > 0 {
+		//工作协程还在执行这个会话的消息,关闭回调放到handleWorkerDone
+		session.closing = true
+		return
+	}
+	manager.NotifyClose(session)
+}
+
+//handlePending 开启恢复后新连接的第一条消息决定是恢复旧会话还是新会话
+func (manager *SessionManager) handlePending(unpacker *msgpack.UnPacker) {
+	now := manager.now()
+	for id, session := range manager.pendmap {
+		session.copymsg()
+		ibyte := session.popmsg()
+		if ibyte == nil {
+			//还没打开的会话,断开时不用通知
+			if now.Sub(session.pendtime) > manager.pendtimeout {
+				delete(manager.pendmap, id)
+				session.ws.Close()
+			}
+			continue
+		}
+		delete(manager.pendmap, id)
+		data := ibyte.([]byte)
+		unpacker.SetMode(session.mode)
+		unpacker.Attatch(data)
+		if r, msgid := unpacker.UnPackUInt32(); r == 0 && msgid == SysMsgIdResume {
+			req := &sysMsgResume{}
+			if req.Unpack(unpacker) == 0 && req.token != "" {
+				if manager.resumeSession(session, req) {
+					continue
+				}
+				session.sendSysMsg(&sysMsgResumeResult{ok: false})
+			}
+			manager.openSession(session)
+			continue
+		}
+		manager.openSession(session)
+		session.dispatch(unpacker, data)
+	}
+}
+
+//resumeSession 把新连接挂到旧会话上并补发消息
+func (manager *SessionManager) resumeSession(session *Session, req *sysMsgResume) bool {
+	old, ok := manager.resumemap[req.token]
+	if !ok {
+		return false
+	}
+	//缓存的消息是按旧编码打包的,换了编码的监听不能补发,只能开新会话
+	if old.mode != session.mode {
+		return false
+	}
+	//补发的消息和工作协程新发的消息都在锁里处理,不会漏掉
+	old.sendlock.Lock()
+	datas, ok := old.resume.replay(req.seq)
+	if !ok {
+		old.sendlock.Unlock()
+		return false
+	}
+	if !old.resume.suspend {
+		//旧连接还没发现断线
+		old.ws.SetWatcher(nil)
+		old.ws.Close()
+	}
+	old.resume.suspend = false
+	old.ws = session.ws
+	old.secure = session.secure
+	old.listener = session.listener
+	if rec := manager.recorder; rec != nil {
+		//之后新连接的消息记在旧会话下
+		var data [8]byte
+		binary.BigEndian.PutUint64(data[:], session.id)
+		rec.write(RecordResume, manager.now(), old.id, 0, data[:])
+	}
+	old.ws.SetWatcher(old)
+	old.send(packMsg(&sysMsgResumeResult{ok: true}, old.mode))
+	for _, data := range datas {
+		old.send(data)
+	}
+	old.sendlock.Unlock()
+	//换watcher之前收到的消息交给旧会话
+	unpacker := manager.popUnPacker()
+	defer msgpack.PushUnPacker(unpacker)
+	for {
+		session.copymsg()
+		ibyte := session.popmsg()
+		if ibyte == nil {
+			break
+		}
+		old.dispatch(unpacker, ibyte.([]byte))
+	}
+	return true
+}
+
+func (manager *SessionManager) checkResume() {
+	if !manager.resumeEnable() {
+		return
+	}
+	now := manager.now()
+	for _, session := range manager.resumemap {
+		if session.resume.expired(now, manager.resumegrace) {
+			manager.closeSession(session)
+		}
+	}
+}
+
+//checkShake 加密握手超时的连接断开,还没打开不用通知
+func (manager *SessionManager) checkShake() {
+	now := manager.now()
+	for id, session := range manager.shakemap {
+		if now.Sub(session.pendtime) > manager.pendtimeout {
+			delete(manager.shakemap, id)
+			session.ws.Close()
+		}
+	}
+}
+
+//full 握手中和等第一条消息的连接也算
+func (manager *SessionManager) full() bool {
+	return manager.maxsession <= uint32(manager.Count()+len(manager.pendmap)+len(manager.shakemap))
+}
+
 func (manager *SessionManager) handleEvent() {
 	manager.copymsg()
 	for {
@@ -96,51 +582,99 @@ func (manager *SessionManager) handleEvent() {
 		}
 		event := ievent.(*sessionEvent)
 		switch event.event {
+		case sessionEventShake:
+			{
+				if manager.full() {
+					event.ws.Close()
+					continue
+				}
+				event.session.pendtime = manager.now()
+				manager.shakemap[event.session.ID()] = event.session
+			}
 		case sessionEventOpen:
 			{
-				if manager.maxsession <= uint32(manager.Count()) {
+				if manager.rsahelp != nil {
+					//握手超时或者连接数满已经断开的不再打开
+					if _, ok := manager.shakemap[event.session.ID()]; !ok {
+						continue
+					}
+					delete(manager.shakemap, event.session.ID())
+				}
+				if manager.full() {
 					event.ws.Close()
 					continue
 				}
-				session := event.ws.GetWatcher().(*Session)
-				manager.ssmap[event.ws.ID()] = session
-				if manager.fsessionOpen != nil {
-					manager.fsessionOpen(session)
+				if manager.resumeEnable() {
+					event.session.pendtime = manager.now()
+					manager.pendmap[event.session.ID()] = event.session
+					continue
 				}
+				manager.openSession(event.session)
 			}
 		case sessionEventClose:
 			{
-				if session := manager.getSession(event.ws.ID()); session != nil {
-					delete(manager.ssmap, event.ws.ID())
-					if manager.fSessionClose != nil {
-						manager.fSessionClose(session)
-					}
+				session := event.session
+				if session.ws != event.ws {
+					//已经恢复到新连接上了
+					continue
+				}
+				if _, ok := manager.pendmap[session.ID()]; ok {
+					delete(manager.pendmap, session.ID())
+					continue
+				}
+				if _, ok := manager.shakemap[session.ID()]; ok {
+					delete(manager.shakemap, session.ID())
+					continue
+				}
+				if manager.getSession(session.ID()) != session {
+					continue
 				}
+				if session.resume != nil {
+					session.resume.suspend = true
+					session.resume.closetime = manager.now()
+					continue
+				}
+				manager.closeSession(session)
 			}
 		}
 	}
+	manager.checkShake()
+	manager.checkResume()
 }
 
 func (manager *SessionManager) handleMsg() {
-	unpacker := msgpack.PopUnPacker()
+	unpacker := manager.popUnPacker()
 	defer msgpack.PushUnPacker(unpacker)
+	manager.handlePending(unpacker)
 	for _, session := range manager.ssmap {
 		session.handleMsg(unpacker)
 	}
 }
 
 func (manager *SessionManager) Run() {
+	manager.handleWorkerDone()
 	manager.handleEvent()
 	manager.handleMsg()
 }
 
 func (manager *SessionManager) Stop() bool {
-	if reslut := manager.server.Stop(); reslut {
-		manager.server.SetWatcher(nil)
+	reslut := false
+	for _, listener := range manager.listeners {
+		if listener.server.Stop() {
+			listener.server.SetWatcher(nil)
+			reslut = true
+		}
+	}
+	if reslut {
+		if manager.workernum > 0 {
+			manager.worker.stop()
+		}
 		manager.ssmap = nil
-		return true
+		manager.pendmap = nil
+		manager.shakemap = nil
+		manager.resumemap = nil
 	}
-	return false
+	return reslut
 }
 
 //BroadcastMsg 广播消息
@@ -155,30 +689,101 @@ func (manager *SessionManager) TraverseAllSession(f func(session ISession)) {
 
 //BroadcastMsg 广播消息
 func (manager *SessionManager) BroadcastMsg(msg protocolbase.IMsg) {
-	packer := msgpack.PopPacker()
-	defer msgpack.PushPacker(packer)
-	msg.Pack(packer, true)
-	data := packer.GetBuffer()
+	//每种编码方式只打包一次
+	var datas [2][]byte
 	for _, session := range manager.ssmap {
-		session.SendBytes(data)
+		if datas[session.mode] == nil {
+			datas[session.mode] = packMsg(msg, session.mode)
+		}
+		session.SendBytes(datas[session.mode])
 	}
 }
 
-//Kick 踢掉指定连接
+//Kick 踢掉指定连接,踢掉的不能再恢复
 func (manager *SessionManager) Kick(id uint64) {
 	if session := manager.getSession(id); session != nil {
-		session.Close()
+		if manager.dropResume(session) {
+			session.Close()
+		}
 	}
 }
 
-func (manager *SessionManager) Start() bool {
-	if reslut := manager.server.Start(); reslut {
-		manager.init()
-		manager.ssmap = make(map[uint64]*Session)
-		manager.server.SetWatcher(manager)
+//KickWithReason 先告诉客户端原因再关闭,已经在发送队列里的消息会先发完
+func (manager *SessionManager) KickWithReason(id uint64, code uint32, text string) {
+	if session := manager.getSession(id); session != nil {
+		manager.kickWithReason(session, code, text)
+	}
+}
+
+//KickAll 踢掉所有会话,比如停服维护
+func (manager *SessionManager) KickAll(code uint32, text string) {
+	for _, session := range manager.ssmap {
+		manager.kickWithReason(session, code, text)
+	}
+}
+
+//KickWhere 踢掉满足条件的会话,返回踢掉的个数,where为nil表示全部
+func (manager *SessionManager) KickWhere(where func(ISession) bool, code uint32, text string) int {
+	count := 0
+	for _, session := range manager.ssmap {
+		if where == nil || where(session) {
+			manager.kickWithReason(session, code, text)
+			count++
+		}
+	}
+	return count
+}
+
+func (manager *SessionManager) kickWithReason(session *Session, code uint32, text string) {
+	if !manager.dropResume(session) {
+		return
+	}
+	session.sendSysMsg(&sysMsgKick{code: code, text: text})
+	session.ws.CloseWithCode(uint16(kickCloseCodeBase+code), text)
+}
+
+//dropResume 踢掉的会话不能再恢复,已经断线等待恢复的直接关闭,返回false
+func (manager *SessionManager) dropResume(session *Session) bool {
+	if session.resume == nil {
 		return true
 	}
-	return false
+	suspend := session.resume.suspend
+	delete(manager.resumemap, session.resume.token)
+	session.sendlock.Lock()
+	session.resume = nil
+	session.sendlock.Unlock()
+	if suspend {
+		manager.closeSession(session)
+		return false
+	}
+	return true
+}
+
+func (manager *SessionManager) Start() bool {
+	if len(manager.listeners) == 0 {
+		return false
+	}
+	manager.init()
+	manager.ssmap = make(map[uint64]*Session)
+	manager.pendmap = make(map[uint64]*Session)
+	manager.shakemap = make(map[uint64]*Session)
+	manager.resumemap = make(map[string]*Session)
+	for i, listener := range manager.listeners {
+		listener.server.SetWatcher(listener)
+		if !listener.server.Start() {
+			//有一个失败就全部关掉
+			for _, started := range manager.listeners[:i] {
+				started.server.Stop()
+				started.server.SetWatcher(nil)
+			}
+			listener.server.SetWatcher(nil)
+			return false
+		}
+	}
+	if manager.workernum > 0 {
+		manager.worker.start(manager.workernum, manager.limits)
+	}
+	return true
 }
 
 func (manager *SessionManager) Count() int {
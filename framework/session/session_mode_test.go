@@ -0,0 +1,29 @@
+package session
+
+import (
+	"g_server/framework/gnet"
+	"g_server/framework/msgpack"
+	"testing"
+)
+
+//TestCodecModeInvalid 不认识的编码方式设不进去,广播按编码方式取下标
+func TestCodecModeInvalid(t *testing.T) {
+	manager := NewSessionManager("test", 100, nil)
+	manager.AddListener("mem", &gnet.MemServer{})
+	for _, mode := range []int{-1, 2, 100} {
+		if manager.SetListenerMode("mem", mode) {
+			t.Fatalf("listener accepted mode %d", mode)
+		}
+	}
+	if !manager.SetListenerMode("mem", msgpack.ModeSpec) {
+		t.Fatal("listener rejected spec mode")
+	}
+	session := &BaseSession{}
+	if session.SetCodecMode(2) || session.CodecMode() != msgpack.ModeLegacy {
+		t.Fatal("session accepted mode 2")
+	}
+	pool := &SessionClientPool{}
+	if pool.SetCodecMode(-1) || !pool.SetCodecMode(msgpack.ModeSpec) || pool.mode != msgpack.ModeSpec {
+		t.Fatal("pool mode check")
+	}
+}
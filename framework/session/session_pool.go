@@ -0,0 +1,307 @@
+package session
+
+import (
+	"g_server/framework/discovery"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type poolSend struct {
+	key  string
+	hash bool
+	data []byte
+}
+
+type poolNode struct {
+	hash uint32
+	curl string
+}
+
+//SessionClientPool 连接一个或多个地址的一组SessionClient,消息注册在池上,所有连接共用
+//没有可用连接时消息先排队,有连接连上再发出去
+type SessionClientPool struct {
+	SessionMsgProxy
+	urls       []string
+	links      map[string][]*SessionClient
+	all        []*SessionClient
+	ring       []poolNode
+	queue      []*poolSend
+	maxqueue   int
+	next       int
+	conns      int
+	rcontime   int32
+	maxmsgsize uint32
+	policy     int
+	backoffmin time.Duration
+	backoffmax time.Duration
+	started    bool
+	seq        int
+	name       string
+	mode       int
+}
+
+//SetCodecMode 池里所有连接的编码方式,Start之前调用,不认识的编码方式返回false
+func (pool *SessionClientPool) SetCodecMode(mode int) bool {
+	if !msgpack.ValidMode(mode) {
+		return false
+	}
+	pool.mode = mode
+	return true
+}
+
+//AddUrl 添加一个地址,Start之后调用会马上连接
+func (pool *SessionClientPool) AddUrl(curl string) bool {
+	if _, ok := pool.links[curl]; ok {
+		return false
+	}
+	clients := make([]*SessionClient, pool.conns)
+	for i := range clients {
+		pool.seq++
+		clients[i] = NewWsSessionClient(pool.name+"."+strconv.Itoa(pool.seq), curl, pool.rcontime, pool.maxmsgsize)
+		if pool.started {
+			pool.startClient(clients[i])
+		}
+	}
+	pool.links[curl] = clients
+	pool.urls = append(pool.urls, curl)
+	pool.rebuild()
+	return true
+}
+
+//RemoveUrl 去掉一个地址,上面的连接都断开
+func (pool *SessionClientPool) RemoveUrl(curl string) bool {
+	clients, ok := pool.links[curl]
+	if !ok {
+		return false
+	}
+	delete(pool.links, curl)
+	for i, u := range pool.urls {
+		if u == curl {
+			pool.urls = append(pool.urls[:i], pool.urls[i+1:]...)
+			break
+		}
+	}
+	for _, client := range clients {
+		//Stop以后不会再处理关闭事件,在这里通知
+		opened := client.opened()
+		client.Stop()
+		if opened {
+			pool.NotifyClose(client)
+		}
+	}
+	pool.rebuild()
+	return true
+}
+
+//WatchDiscovery 某个角色的节点地址自动加入和移出连接池
+func (pool *SessionClientPool) WatchDiscovery(disc discovery.IDiscovery, role string) {
+	disc.Watch(func(event int, node *discovery.NodeRecord) {
+		if node.Role != role {
+			return
+		}
+		switch event {
+		case discovery.NodeAdd:
+			pool.AddUrl(node.Url)
+		case discovery.NodeRemove:
+			pool.RemoveUrl(node.Url)
+		}
+	})
+}
+
+//SetBackoff 所有连接的重连间隔,Start之前调用,不能小于BackoffFloor
+func (pool *SessionClientPool) SetBackoff(min time.Duration, max time.Duration) {
+	if min < BackoffFloor {
+		min = BackoffFloor
+	}
+	if max < min {
+		max = min
+	}
+	pool.backoffmin = min
+	pool.backoffmax = max
+}
+
+//SetMaxQueue 没有连接时最多排队的消息数,0表示不排队
+func (pool *SessionClientPool) SetMaxQueue(maxqueue int) {
+	pool.maxqueue = maxqueue
+}
+
+//Healthy 当前可用的连接数
+func (pool *SessionClientPool) Healthy() int {
+	count := 0
+	for _, client := range pool.all {
+		if client.Valid() {
+			count++
+		}
+	}
+	return count
+}
+
+//Clients 所有连接
+func (pool *SessionClientPool) Clients() []*SessionClient {
+	return pool.all
+}
+
+func (pool *SessionClientPool) rebuild() {
+	//重新分配,Run里遍历的旧列表不受影响
+	pool.all = make([]*SessionClient, 0, len(pool.urls)*pool.conns)
+	pool.ring = make([]poolNode, 0, len(pool.urls)*poolHashReplicas)
+	for _, curl := range pool.urls {
+		pool.all = append(pool.all, pool.links[curl]...)
+		for i := 0; i < poolHashReplicas; i++ {
+			pool.ring = append(pool.ring, poolNode{hash: crc32.ChecksumIEEE([]byte(curl + "#" + strconv.Itoa(i))), curl: curl})
+		}
+	}
+	sort.Slice(pool.ring, func(i, j int) bool {
+		return pool.ring[i].hash < pool.ring[j].hash
+	})
+}
+
+func (pool *SessionClientPool) startClient(client *SessionClient) {
+	//用池的注册表,排队的消息在Run里连接处理完打开后发出去
+	client.proxy = &pool.SessionMsgProxy
+	client.SetCodecMode(pool.mode)
+	if pool.backoffmin > 0 {
+		client.SetBackoff(pool.backoffmin, pool.backoffmax)
+	}
+	client.Start()
+}
+
+//Select 按选择方式取一个可用的连接,没有返回nil,key只在PoolConsistentHash时使用
+func (pool *SessionClientPool) Select(key string) *SessionClient {
+	if len(pool.all) == 0 {
+		return nil
+	}
+	switch pool.policy {
+	case PoolLeastPending:
+		return pool.selectLeast()
+	case PoolConsistentHash:
+		return pool.selectHash(key)
+	}
+	return pool.selectNext()
+}
+
+func (pool *SessionClientPool) selectNext() *SessionClient {
+	if len(pool.all) == 0 {
+		return nil
+	}
+	for i := 0; i < len(pool.all); i++ {
+		pool.next = (pool.next + 1) % len(pool.all)
+		if client := pool.all[pool.next]; client.Valid() {
+			return client
+		}
+	}
+	return nil
+}
+
+func (pool *SessionClientPool) selectLeast() *SessionClient {
+	var best *SessionClient
+	for _, client := range pool.all {
+		if client.Valid() && (best == nil || client.Pending() < best.Pending()) {
+			best = client
+		}
+	}
+	return best
+}
+
+//selectHash 同一个key总是落到同一个地址,地址上的连接全断了就顺着环找下一个地址
+func (pool *SessionClientPool) selectHash(key string) *SessionClient {
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(pool.ring), func(i int) bool {
+		return pool.ring[i].hash >= hash
+	})
+	tried := make(map[string]bool)
+	for i := 0; i < len(pool.ring) && len(tried) < len(pool.urls); i++ {
+		curl := pool.ring[(start+i)%len(pool.ring)].curl
+		if tried[curl] {
+			continue
+		}
+		tried[curl] = true
+		clients := pool.links[curl]
+		for j := 0; j < len(clients); j++ {
+			if client := clients[(int(hash%uint32(len(clients)))+j)%len(clients)]; client.Valid() {
+				return client
+			}
+		}
+	}
+	return nil
+}
+
+//SendMsg 按选择方式发送,没有可用连接就排队,队列满了返回false
+func (pool *SessionClientPool) SendMsg(msg protocolbase.IMsg) bool {
+	return pool.SendBytes(packMsg(msg, pool.mode))
+}
+
+func (pool *SessionClientPool) SendBytes(data []byte) bool {
+	return pool.send(&poolSend{data: data})
+}
+
+//SendMsgByKey 同一个key的消息发到同一个地址,只在PoolConsistentHash时有意义,其他方式忽略key
+func (pool *SessionClientPool) SendMsgByKey(key string, msg protocolbase.IMsg) bool {
+	return pool.send(&poolSend{key: key, hash: true, data: packMsg(msg, pool.mode)})
+}
+
+func (pool *SessionClientPool) selectFor(item *poolSend) *SessionClient {
+	if !item.hash && pool.policy == PoolConsistentHash {
+		return pool.selectNext()
+	}
+	return pool.Select(item.key)
+}
+
+func (pool *SessionClientPool) send(item *poolSend) bool {
+	//前面还有排队的消息就不能插队
+	if len(pool.queue) == 0 {
+		if client := pool.selectFor(item); client != nil {
+			client.SendBytes(item.data)
+			return true
+		}
+	}
+	if len(pool.queue) >= pool.maxqueue {
+		return false
+	}
+	pool.queue = append(pool.queue, item)
+	return true
+}
+
+func (pool *SessionClientPool) flush() {
+	for len(pool.queue) > 0 {
+		item := pool.queue[0]
+		client := pool.selectFor(item)
+		if client == nil {
+			return
+		}
+		client.SendBytes(item.data)
+		pool.queue[0] = nil
+		pool.queue = pool.queue[1:]
+	}
+}
+
+func (pool *SessionClientPool) Run() {
+	for _, client := range pool.all {
+		client.Run()
+	}
+	pool.flush()
+}
+
+func (pool *SessionClientPool) Start() bool {
+	pool.started = true
+	for _, client := range pool.all {
+		pool.startClient(client)
+	}
+	return true
+}
+
+func (pool *SessionClientPool) Stop() bool {
+	pool.started = false
+	for _, client := range pool.all {
+		client.Stop()
+	}
+	return true
+}
+
+func (pool *SessionClientPool) Name() string {
+	return pool.name
+}
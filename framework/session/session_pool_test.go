@@ -0,0 +1,84 @@
+//go:build !race
+
+//gnet.WebSocket的状态和发送队列没有加锁,关闭时-race会报,走本地websocket的测试不带-race跑
+
+package session
+
+import (
+	"fmt"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"net"
+	"testing"
+	"time"
+)
+
+//testPoolServer 本地websocket服务器,连上就发一条testMsg和一条没注册的消息
+func testPoolServer(t *testing.T) (*SessionManager, string) {
+	t.Helper()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listen.Addr().String()
+	listen.Close()
+	manager := NewWsSessionManager("server", addr, 1<<16, 16, nil)
+	manager.RegSessionOpen(func(s ISession) {
+		s.SendMsg(&testMsg{value: 7})
+		packer := msgpack.PopPacker()
+		packer.ClearBuffer()
+		packer.PackUInt32(testMsgId + 1)
+		s.SendBytes(packer.GetBuffer())
+		msgpack.PushPacker(packer)
+	})
+	if !manager.Start() {
+		t.Fatal("server start fail")
+	}
+	t.Cleanup(func() { manager.Stop() })
+	return manager, fmt.Sprintf("ws://%s/", addr)
+}
+
+//TestPoolRegAfterStart Start之后在池上注册的回调和消息,已有的连接也要用
+func TestPoolRegAfterStart(t *testing.T) {
+	server, curl := testPoolServer(t)
+	pool := NewWsSessionClientPool("pool", []string{curl}, 2, 1, 1<<16, PoolRoundRobin)
+	pool.Start()
+	defer pool.Stop()
+
+	var opens, closes, values, unknowns int
+	pool.RegSessionOpen(func(s ISession) { opens++ })
+	pool.RegSessionClose(func(s ISession) { closes++ })
+	pool.RegIMsgHandler(testMsgId, func(s ISession, msg protocolbase.IMsg, ok bool) {
+		if ok && msg.(*testMsg).value == 7 {
+			values++
+		}
+	}, func() protocolbase.IMsg { return &testMsg{} })
+	pool.RegUnknownMsg(func(s ISession, id uint32, data []byte) {
+		if id == testMsgId+1 {
+			unknowns++
+		}
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	run := func(cond func() bool) {
+		t.Helper()
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout opens %d values %d unknowns %d", opens, values, unknowns)
+			}
+			server.Run()
+			pool.Run()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	run(func() bool { return opens == 2 && values == 2 && unknowns == 2 })
+
+	//去掉地址时已经打开的连接都要通知关闭
+	pool.RemoveUrl(curl)
+	if closes != 2 {
+		t.Fatalf("closes %d after RemoveUrl", closes)
+	}
+	if pool.Healthy() != 0 || len(pool.Clients()) != 0 {
+		t.Fatalf("healthy %d clients %d", pool.Healthy(), len(pool.Clients()))
+	}
+}
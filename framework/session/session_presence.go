@@ -0,0 +1,120 @@
+package session
+
+import (
+	"errors"
+)
+
+var (
+	ErrPresenceBound = errors.New("Err PresenceBound")
+)
+
+type presenceKey struct {
+	kind string
+	key  string
+}
+
+//IPresenceStore 全服在线,本地绑定和解绑时同步过去,网关等其他节点可以查询
+type IPresenceStore interface {
+	Online(kind string, key string)
+	Offline(kind string, key string)
+	Query(kind string, key string, callback func(uint32, bool))
+}
+
+//Presence 外部的键(用户ID,角色ID)到会话的索引,同一种键的一个值只能绑一个会话,一个会话每种键只有一个值
+//可以给多个SessionManager共用,UsePresence之后会话关闭自动解绑,只在主循环使用
+type Presence struct {
+	keys     map[presenceKey]ISession
+	sessions map[ISession]map[string]string
+	store    IPresenceStore
+}
+
+//SetStore 设置全服在线,nil表示只有本地
+func (presence *Presence) SetStore(store IPresenceStore) {
+	presence.store = store
+}
+
+//Bind 把键绑到会话上,已经绑在别的会话上返回ErrPresenceBound,会话原来这种键的值会被替换
+func (presence *Presence) Bind(kind string, key string, session ISession) error {
+	pkey := presenceKey{kind: kind, key: key}
+	if old, ok := presence.keys[pkey]; ok {
+		if old == session {
+			return nil
+		}
+		return ErrPresenceBound
+	}
+	presence.Unbind(kind, session)
+	presence.keys[pkey] = session
+	kinds, ok := presence.sessions[session]
+	if !ok {
+		kinds = make(map[string]string)
+		presence.sessions[session] = kinds
+	}
+	kinds[kind] = key
+	if presence.store != nil {
+		presence.store.Online(kind, key)
+	}
+	return nil
+}
+
+//Unbind 解绑会话上这种键
+func (presence *Presence) Unbind(kind string, session ISession) bool {
+	kinds, ok := presence.sessions[session]
+	if !ok {
+		return false
+	}
+	key, ok := kinds[kind]
+	if !ok {
+		return false
+	}
+	delete(kinds, kind)
+	if len(kinds) == 0 {
+		delete(presence.sessions, session)
+	}
+	delete(presence.keys, presenceKey{kind: kind, key: key})
+	if presence.store != nil {
+		presence.store.Offline(kind, key)
+	}
+	return true
+}
+
+//UnbindAll 解绑会话上所有的键
+func (presence *Presence) UnbindAll(session ISession) {
+	for kind := range presence.sessions[session] {
+		presence.Unbind(kind, session)
+	}
+}
+
+//Lookup 本地找绑定的会话,没有返回nil
+func (presence *Presence) Lookup(kind string, key string) ISession {
+	return presence.keys[presenceKey{kind: kind, key: key}]
+}
+
+//KeyOf 会话上这种键的值
+func (presence *Presence) KeyOf(kind string, session ISession) (string, bool) {
+	key, ok := presence.sessions[session][kind]
+	return key, ok
+}
+
+//Count 这种键绑定的个数
+func (presence *Presence) Count(kind string) int {
+	count := 0
+	for pkey := range presence.keys {
+		if pkey.kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+//Query 先查本地,本地没有再查全服,callback的参数是所在节点,本地找到时节点为0
+func (presence *Presence) Query(kind string, key string, callback func(uint32, bool)) {
+	if presence.Lookup(kind, key) != nil {
+		callback(0, true)
+		return
+	}
+	if presence.store == nil {
+		callback(0, false)
+		return
+	}
+	presence.store.Query(kind, key, callback)
+}
@@ -0,0 +1,232 @@
+package session
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"g_server/framework/gnet"
+	"g_server/framework/msgpack"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRecordHeader = errors.New("Err RecordHeader")
+	ErrRecordData   = errors.New("Err RecordData")
+	ErrRecordReplay = errors.New("Err RecordReplay")
+)
+
+//SessionRecord 一条记录,Data是解密后的整个消息,RecordOpen时是客户端地址
+type SessionRecord struct {
+	Type      byte
+	Time      time.Time
+	SessionId uint64
+	MsgId     uint32
+	Data      []byte
+}
+
+//SessionRecorder 把收发的消息写到二进制文件
+//每条记录:类型1字节,纳秒时间8字节,会话ID8字节,消息号4字节,长度4字节,数据
+type SessionRecorder struct {
+	lock   sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	err    error
+}
+
+func (rec *SessionRecorder) write(typ byte, now time.Time, sid uint64, msgid uint32, data []byte) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if rec.err != nil {
+		return
+	}
+	var head [recordHeadSize]byte
+	head[0] = typ
+	binary.BigEndian.PutUint64(head[1:], uint64(now.UnixNano()))
+	binary.BigEndian.PutUint64(head[9:], sid)
+	binary.BigEndian.PutUint32(head[17:], msgid)
+	binary.BigEndian.PutUint32(head[21:], uint32(len(data)))
+	if _, rec.err = rec.w.Write(head[:]); rec.err == nil {
+		_, rec.err = rec.w.Write(data)
+	}
+}
+
+//writeMsg 消息号从数据里解出来,解不出来记0
+func (rec *SessionRecorder) writeMsg(typ byte, now time.Time, sid uint64, mode int, data []byte) {
+	unpacker := msgpack.PopUnPacker()
+	defer msgpack.PushUnPacker(unpacker)
+	unpacker.SetMode(mode)
+	unpacker.Attatch(data)
+	_, msgid := unpacker.UnPackUInt32()
+	rec.write(typ, now, sid, msgid, data)
+}
+
+//Err 第一次写失败的错误,失败之后不再记录
+func (rec *SessionRecorder) Err() error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return rec.err
+}
+
+func (rec *SessionRecorder) Flush() error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if rec.err != nil {
+		return rec.err
+	}
+	return rec.w.Flush()
+}
+
+//Close 写完缓存,文件是NewFileSessionRecorder打开的会一起关掉
+func (rec *SessionRecorder) Close() error {
+	err := rec.Flush()
+	if rec.closer != nil {
+		if cerr := rec.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+//SessionRecordReader 按顺序读记录
+type SessionRecordReader struct {
+	r *bufio.Reader
+}
+
+//Next 读下一条,读完返回io.EOF
+func (reader *SessionRecordReader) Next() (*SessionRecord, error) {
+	var head [recordHeadSize]byte
+	if _, err := io.ReadFull(reader.r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrRecordData
+		}
+		return nil, err
+	}
+	rec := &SessionRecord{
+		Type:      head[0],
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(head[1:]))),
+		SessionId: binary.BigEndian.Uint64(head[9:]),
+		MsgId:     binary.BigEndian.Uint32(head[17:]),
+		Data:      make([]byte, binary.BigEndian.Uint32(head[21:]))}
+	if _, err := io.ReadFull(reader.r, rec.Data); err != nil {
+		return nil, ErrRecordData
+	}
+	return rec, nil
+}
+
+//SessionReplay 把记录的客户端消息按原来的顺序和时间喂给一个新的SessionManager,用来重现玩家报的问题
+//manager用内存监听和虚拟时钟,每条记录之后Run一次,不要开启工作协程和加密
+type SessionReplay struct {
+	manager *SessionManager
+	server  *gnet.MemServer
+	sockets map[uint64]*gnet.MemSocket
+	clock   time.Time
+	filter  uint64
+	fsend   func(uint64, []byte)
+}
+
+//Manager 回放用的SessionManager,在上面注册业务消息
+func (replay *SessionReplay) Manager() *SessionManager {
+	return replay.manager
+}
+
+//FilterSession 只回放记录里的某个会话,0表示全部
+func (replay *SessionReplay) FilterSession(id uint64) {
+	replay.filter = id
+}
+
+//RegSend 回放时服务器发出的消息,ID是记录里的会话ID
+func (replay *SessionReplay) RegSend(f func(uint64, []byte)) {
+	replay.fsend = f
+}
+
+//Now 当前回放到的时间
+func (replay *SessionReplay) Now() time.Time {
+	return replay.clock
+}
+
+func (replay *SessionReplay) sendHook(sid uint64) func([]byte) {
+	return func(data []byte) {
+		if replay.fsend != nil {
+			replay.fsend(sid, data)
+		}
+	}
+}
+
+func (replay *SessionReplay) feed(rec *SessionRecord) {
+	switch rec.Type {
+	case RecordOpen:
+		ws := replay.server.Dial(string(rec.Data))
+		if ws == nil {
+			return
+		}
+		ws.SetSendHook(replay.sendHook(rec.SessionId))
+		replay.sockets[rec.SessionId] = ws
+	case RecordIn:
+		if ws, ok := replay.sockets[rec.SessionId]; ok {
+			ws.Recv(rec.Data)
+		}
+	case RecordResume:
+		if len(rec.Data) != 8 {
+			return
+		}
+		newid := binary.BigEndian.Uint64(rec.Data)
+		if ws, ok := replay.sockets[newid]; ok {
+			delete(replay.sockets, newid)
+			ws.SetSendHook(replay.sendHook(rec.SessionId))
+			replay.sockets[rec.SessionId] = ws
+		}
+	case RecordClose:
+		if ws, ok := replay.sockets[rec.SessionId]; ok {
+			delete(replay.sockets, rec.SessionId)
+			ws.Close()
+		}
+	}
+}
+
+//Replay 回放到记录结束,剩下的连接全部断开
+func (replay *SessionReplay) Replay(reader *SessionRecordReader) error {
+	replay.manager.SetClock(replay.Now)
+	if !replay.manager.Start() {
+		return ErrRecordReplay
+	}
+	defer replay.manager.Stop()
+	var err error
+	for {
+		var rec *SessionRecord
+		if rec, err = reader.Next(); err != nil {
+			break
+		}
+		if rec.Type == RecordOut || (replay.filter != 0 && rec.SessionId != replay.filter) {
+			continue
+		}
+		replay.clock = rec.Time
+		replay.feed(rec)
+		replay.manager.Run()
+	}
+	for sid, ws := range replay.sockets {
+		delete(replay.sockets, sid)
+		ws.Close()
+	}
+	replay.manager.Run()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+//ReplayFile 回放记录文件
+func (replay *SessionReplay) ReplayFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := NewSessionRecordReader(file)
+	if err != nil {
+		return err
+	}
+	return replay.Replay(reader)
+}
@@ -0,0 +1,82 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+type resumeItem struct {
+	seq  uint64
+	data []byte
+}
+
+//sessionResume 会话恢复数据,保存最近发出的消息用于断线重连后补发
+type sessionResume struct {
+	token     string
+	seq       uint64
+	buff      []resumeItem
+	head      int
+	count     int
+	suspend   bool
+	closetime time.Time
+}
+
+func newSessionResume(size int) (*sessionResume, error) {
+	if size <= 0 {
+		size = 1
+	}
+	token, err := genResumeToken()
+	if err != nil {
+		return nil, err
+	}
+	return &sessionResume{token: token, buff: make([]resumeItem, size)}, nil
+}
+
+//genResumeToken 凭证能拿来接管别人的会话,取不到随机数不能用别的代替
+func genResumeToken() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+//wrap 加上序号并保存,返回真正要发送的数据
+func (resume *sessionResume) wrap(data []byte, mode int) []byte {
+	resume.seq++
+	out := packMsg(&sysMsgSeq{seq: resume.seq, data: data}, mode)
+	pos := (resume.head + resume.count) % len(resume.buff)
+	resume.buff[pos] = resumeItem{seq: resume.seq, data: out}
+	if resume.count < len(resume.buff) {
+		resume.count++
+	} else {
+		resume.head = (resume.head + 1) % len(resume.buff)
+	}
+	return out
+}
+
+//replay 取出seq之后的消息,已经被挤出缓存的话返回false
+func (resume *sessionResume) replay(seq uint64) ([][]byte, bool) {
+	if seq > resume.seq {
+		return nil, false
+	}
+	if seq == resume.seq {
+		return nil, true
+	}
+	if resume.count == 0 || resume.buff[resume.head].seq > seq+1 {
+		return nil, false
+	}
+	datas := make([][]byte, 0, resume.seq-seq)
+	for i := 0; i < resume.count; i++ {
+		item := resume.buff[(resume.head+i)%len(resume.buff)]
+		if item.seq > seq {
+			datas = append(datas, item.data)
+		}
+	}
+	return datas, true
+}
+
+func (resume *sessionResume) expired(now time.Time, grace time.Duration) bool {
+	return resume.suspend && now.Sub(resume.closetime) >= grace
+}
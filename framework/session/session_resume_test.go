@@ -0,0 +1,102 @@
+package session
+
+import (
+	"g_server/framework/gnet"
+	"g_server/framework/msgpack"
+	"testing"
+	"time"
+)
+
+//TestResumePendingTimeout 连上来不发消息的连接超时断开,不会变成会话
+func TestResumePendingTimeout(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var opened, closed int
+	manager, server := testManager(t, func(manager *SessionManager) {
+		manager.EnableResume(time.Minute, 16)
+		manager.SetClock(func() time.Time { return now })
+		manager.RegSessionOpen(func(s ISession) { opened++ })
+		manager.RegSessionClose(func(s ISession) { closed++ })
+	})
+	idle := server.Dial("idle")
+	manager.Run()
+	now = now.Add(DefaultPendingTimeout / 2)
+	active := server.Dial("active")
+	manager.Run()
+	if idle.State() != gnet.WsStateConnected {
+		t.Fatal("closed before timeout")
+	}
+	now = now.Add(DefaultPendingTimeout/2 + time.Second)
+	active.Recv(packMsg(&testMsg{value: 1}, msgpack.ModeLegacy))
+	manager.Run()
+	manager.Run()
+	if idle.State() != gnet.WsStateClosed {
+		t.Fatal("idle pending socket not closed")
+	}
+	if active.State() != gnet.WsStateConnected || manager.Count() != 1 || opened != 1 || closed != 0 {
+		t.Fatalf("active %d count %d opened %d closed %d", active.State(), manager.Count(), opened, closed)
+	}
+}
+
+//TestResumeToken 凭证不能重复
+func TestResumeToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token, err := genResumeToken()
+		if err != nil || len(token) != 32 || seen[token] {
+			t.Fatalf("token %q %v", token, err)
+		}
+		seen[token] = true
+	}
+}
+
+//TestResumeListener 从别的监听恢复要换成新监听,编码不同的监听不能恢复
+func TestResumeListener(t *testing.T) {
+	other, spec := &gnet.MemServer{}, &gnet.MemServer{}
+	manager, server := testManager(t, func(manager *SessionManager) {
+		manager.EnableResume(time.Minute, 16)
+		manager.AddListener("other", other)
+		manager.AddListener("spec", spec)
+		manager.SetListenerMode("spec", msgpack.ModeSpec)
+	})
+	first := server.Dial("first")
+	first.Recv(packMsg(&sysMsgResume{}, msgpack.ModeLegacy))
+	manager.Run()
+	old := sessionOf(manager, first)
+	if old == nil {
+		t.Fatal("no session")
+	}
+	token := old.resume.token
+	first.Close()
+	manager.Run()
+
+	second := other.Dial("second")
+	second.Recv(packMsg(&sysMsgResume{token: token}, msgpack.ModeLegacy))
+	manager.Run()
+	manager.Run()
+	if manager.Count() != 1 || old.ws != second || old.Listener() != "other" {
+		t.Fatalf("count %d listener %s", manager.Count(), old.Listener())
+	}
+
+	second.Close()
+	manager.Run()
+	third := spec.Dial("third")
+	third.Recv(packMsg(&sysMsgResume{token: token}, msgpack.ModeSpec))
+	manager.Run()
+	manager.Run()
+	if old.ws != second || manager.Count() != 2 {
+		t.Fatalf("resumed across modes, count %d", manager.Count())
+	}
+	if s := sessionOf(manager, third); s == nil || s.CodecMode() != msgpack.ModeSpec || s.Listener() != "spec" {
+		t.Fatal("new session not opened on spec listener")
+	}
+}
+
+//sessionOf 会话ID是管理器分配的,按连接找
+func sessionOf(manager *SessionManager, ws *gnet.MemSocket) *Session {
+	for _, session := range manager.ssmap {
+		if session.ws == ws {
+			return session
+		}
+	}
+	return nil
+}
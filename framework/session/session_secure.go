@@ -0,0 +1,155 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"g_server/framework/crypto"
+	"g_server/framework/msgpack"
+)
+
+const (
+	secureKeySize   = 32
+	secureNonceSize = 32
+	secureSeqSize   = 8
+	secureDirServer = uint32(1) //服务器发往客户端
+	secureDirClient = uint32(2) //客户端发往服务器
+)
+
+var (
+	ErrSecureKey       = errors.New("Err SecureKey")
+	ErrSecureHandshake = errors.New("Err SecureHandshake")
+	ErrSecureSeq       = errors.New("Err SecureSeq")
+	ErrSecureData      = errors.New("Err SecureData")
+)
+
+//secureCipher AES-GCM加密,每个包前面带8字节序号,序号必须连续递增防止重放
+type secureCipher struct {
+	aead    cipher.AEAD
+	senddir uint32
+	recvdir uint32
+	sendseq uint64
+	recvseq uint64
+}
+
+func newSecureCipher(key []byte, server bool) (*secureCipher, error) {
+	if len(key) != secureKeySize {
+		return nil, ErrSecureKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if server {
+		return &secureCipher{aead: aead, senddir: secureDirServer, recvdir: secureDirClient}, nil
+	}
+	return &secureCipher{aead: aead, senddir: secureDirClient, recvdir: secureDirServer}, nil
+}
+
+func (secure *secureCipher) nonce(dir uint32, seq uint64) []byte {
+	nonce := make([]byte, secure.aead.NonceSize())
+	binary.BigEndian.PutUint32(nonce, dir)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-secureSeqSize:], seq)
+	return nonce
+}
+
+//seal 加密,调用方保证不会同时调用
+func (secure *secureCipher) seal(data []byte) []byte {
+	secure.sendseq++
+	out := make([]byte, secureSeqSize, secureSeqSize+len(data)+secure.aead.Overhead())
+	binary.BigEndian.PutUint64(out, secure.sendseq)
+	return secure.aead.Seal(out, secure.nonce(secure.senddir, secure.sendseq), data, out[:secureSeqSize])
+}
+
+//open 解密,只在网络协程调用
+func (secure *secureCipher) open(data []byte) ([]byte, error) {
+	if len(data) < secureSeqSize {
+		return nil, ErrSecureData
+	}
+	seq := binary.BigEndian.Uint64(data)
+	if seq != secure.recvseq+1 {
+		return nil, ErrSecureSeq
+	}
+	out, err := secure.aead.Open(nil, secure.nonce(secure.recvdir, seq), data[secureSeqSize:], data[:secureSeqSize])
+	if err != nil {
+		return nil, err
+	}
+	secure.recvseq = seq
+	return out, nil
+}
+
+//secureKey 会话密钥由客户端的密钥和服务器每次连接新生成的随机数算出来,
+//重放录下来的握手和数据包得到的是另一个密钥,数据包解不开
+func secureKey(key []byte, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+//secureAccept 服务器解出客户端发来的密钥,返回要回给客户端的随机数包
+func secureAccept(rsahelp *crypto.CryptoRsaHelp, data []byte, mode int) (*secureCipher, []byte, error) {
+	unpacker := msgpack.PopUnPacker()
+	defer msgpack.PushUnPacker(unpacker)
+	unpacker.SetMode(mode)
+	unpacker.Attatch(data)
+	if r, id := unpacker.UnPackUInt32(); r != 0 || id != SysMsgIdSecureKey {
+		return nil, nil, ErrSecureHandshake
+	}
+	msg := &sysMsgSecureKey{}
+	if msg.Unpack(unpacker) != 0 {
+		return nil, nil, ErrSecureHandshake
+	}
+	key, err := rsahelp.Decrypt(msg.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(key) != secureKeySize {
+		return nil, nil, ErrSecureKey
+	}
+	nonce := make([]byte, secureNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	secure, err := newSecureCipher(secureKey([]byte(key), nonce), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return secure, packMsg(&sysMsgSecureNonce{nonce: nonce}, mode), nil
+}
+
+//secureConnect 客户端生成密钥,返回密钥和要发给服务器的握手包,收到服务器的随机数后再用secureFinish
+func secureConnect(rsahelp *crypto.CryptoRsaHelp, mode int) ([]byte, []byte, error) {
+	key := make([]byte, secureKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	enkey, err := rsahelp.Encrypt(string(key))
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, packMsg(&sysMsgSecureKey{key: enkey}, mode), nil
+}
+
+//secureFinish 客户端用服务器发来的随机数算出会话密钥
+func secureFinish(key []byte, data []byte, mode int) (*secureCipher, error) {
+	unpacker := msgpack.PopUnPacker()
+	defer msgpack.PushUnPacker(unpacker)
+	unpacker.SetMode(mode)
+	unpacker.Attatch(data)
+	if r, id := unpacker.UnPackUInt32(); r != 0 || id != SysMsgIdSecureNonce {
+		return nil, ErrSecureHandshake
+	}
+	msg := &sysMsgSecureNonce{}
+	if msg.Unpack(unpacker) != 0 || len(msg.nonce) != secureNonceSize {
+		return nil, ErrSecureHandshake
+	}
+	return newSecureCipher(secureKey(key, msg.nonce), false)
+}
@@ -0,0 +1,151 @@
+package session
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"g_server/framework/crypto"
+	"g_server/framework/gnet"
+	"g_server/framework/msgpack"
+	"testing"
+	"time"
+)
+
+func testRsa(t *testing.T) *crypto.CryptoRsaHelp {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsahelp := &crypto.CryptoRsaHelp{}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := rsahelp.InitPrivateKeyPem(data); err != nil {
+		t.Fatal(err)
+	}
+	return rsahelp
+}
+
+//TestSecureHandshake 双方算出同一个密钥,包能互相解开
+func TestSecureHandshake(t *testing.T) {
+	rsahelp := testRsa(t)
+	key, hello, err := secureConnect(rsahelp, msgpack.ModeLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, reply, err := secureAccept(rsahelp, hello, msgpack.ModeLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := secureFinish(key, reply, msgpack.ModeLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"first", "second"} {
+		out, err := server.open(client.seal([]byte(text)))
+		if err != nil || string(out) != text {
+			t.Fatalf("client->server %q %v", out, err)
+		}
+		out, err = client.open(server.seal([]byte(text)))
+		if err != nil || string(out) != text {
+			t.Fatalf("server->client %q %v", out, err)
+		}
+	}
+}
+
+//TestSecureReplay 录下来的握手和数据包发到新连接上解不开
+func TestSecureReplay(t *testing.T) {
+	rsahelp := testRsa(t)
+	key, hello, err := secureConnect(rsahelp, msgpack.ModeLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, reply, err := secureAccept(rsahelp, hello, msgpack.ModeLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := secureFinish(key, reply, msgpack.ModeLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := client.seal([]byte("buy item"))
+	if _, err := server.open(frame); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.open(frame); err != ErrSecureSeq {
+		t.Fatalf("same connection replay: %v", err)
+	}
+
+	replay, again, err := secureAccept(rsahelp, hello, msgpack.ModeLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(reply, again) {
+		t.Fatal("server nonce reused")
+	}
+	if out, err := replay.open(frame); err == nil {
+		t.Fatalf("replayed frame opened on new connection: %q", out)
+	}
+}
+
+func TestSecureBadNonce(t *testing.T) {
+	key := make([]byte, secureKeySize)
+	short := packMsg(&sysMsgSecureNonce{nonce: []byte{1, 2, 3}}, msgpack.ModeLegacy)
+	if _, err := secureFinish(key, short, msgpack.ModeLegacy); err != ErrSecureHandshake {
+		t.Fatalf("short nonce: %v", err)
+	}
+	other := packMsg(&sysMsgKick{code: 1}, msgpack.ModeLegacy)
+	if _, err := secureFinish(key, other, msgpack.ModeLegacy); err != ErrSecureHandshake {
+		t.Fatalf("wrong message: %v", err)
+	}
+}
+
+//TestSecureShakePending 不发密钥的连接算在连接数里,超时断开
+func TestSecureShakePending(t *testing.T) {
+	rsahelp := testRsa(t)
+	now := time.Unix(1000, 0)
+	manager := NewSessionManager("test", 2, nil)
+	server := gnet.NewMemServer(1 << 16)
+	manager.AddListener("mem", server)
+	manager.EnableSecure(rsahelp)
+	manager.SetClock(func() time.Time { return now })
+	if !manager.Start() {
+		t.Fatal("start fail")
+	}
+	defer manager.Stop()
+
+	idle := []*gnet.MemSocket{server.Dial("a"), server.Dial("b")}
+	manager.Run()
+	if len(manager.shakemap) != 2 {
+		t.Fatalf("shaking %d", len(manager.shakemap))
+	}
+	full := server.Dial("c")
+	manager.Run()
+	if full.State() != gnet.WsStateClosed {
+		t.Fatal("accepted over maxsession while handshakes pending")
+	}
+
+	now = now.Add(DefaultPendingTimeout + time.Second)
+	manager.Run()
+	for _, ws := range idle {
+		if ws.State() != gnet.WsStateClosed {
+			t.Fatal("handshake not timed out")
+		}
+	}
+	if len(manager.shakemap) != 0 {
+		t.Fatalf("shaking %d after timeout", len(manager.shakemap))
+	}
+
+	ws := server.Dial("d")
+	manager.Run()
+	_, hello, err := secureConnect(rsahelp, msgpack.ModeLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Recv(hello)
+	manager.Run()
+	if manager.Count() != 1 || len(manager.shakemap) != 0 {
+		t.Fatalf("count %d shaking %d", manager.Count(), len(manager.shakemap))
+	}
+}
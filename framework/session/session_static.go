@@ -1,19 +1,153 @@
 package session
 
 import (
+	"bufio"
 	"g_server/framework/gnet"
+	"io"
+	"os"
+	"time"
 )
 
 const (
 	sessionEventOpen  = 1
 	sessionEventClose = 2
 	sessionEventMsg   = 3
+	sessionEventShake = 4 //加密会话连上,等客户端发密钥
+
+	//框架内部消息号,业务消息不能使用这个段
+	SysMsgIdBase         = uint32(0xffff0000)
+	SysMsgIdSeq          = SysMsgIdBase + 1 //带序号的下行消息
+	SysMsgIdResumeToken  = SysMsgIdBase + 2 //下发恢复凭证
+	SysMsgIdResume       = SysMsgIdBase + 3 //请求恢复会话
+	SysMsgIdResumeResult = SysMsgIdBase + 4 //恢复结果
+	SysMsgIdSecureKey    = SysMsgIdBase + 5 //客户端发来的加密密钥
+	SysMsgIdKick         = SysMsgIdBase + 6 //踢人原因
+	SysMsgIdSecureNonce  = SysMsgIdBase + 7 //服务器回给客户端的随机数
+
+	//踢人原因,业务自己的原因从KickCodeUser开始
+	KickCodeNormal    = uint32(1)
+	KickCodeBanned    = uint32(2) //封号
+	KickCodeDuplicate = uint32(3) //别处登录
+	KickCodeMaintain  = uint32(4) //停服维护
+	KickCodeMalformed = uint32(5) //错误消息太多
+	KickCodeFlood     = uint32(6) //发消息太快
+	KickCodeBackend   = uint32(7) //网关后面的服务器关闭了会话或者断开了
+	KickCodeUser      = uint32(100)
+
+	//WebSocket关闭码,4000以上给应用使用
+	kickCloseCodeBase = 4000
+
+	backoffMaxTimes = 32
+	//重连间隔的下限,设成0的话连不上时会一直空转
+	BackoffFloor = 100 * time.Millisecond
+
+	//开启恢复或加密后新连接等第一条消息的时间,超时就断开
+	DefaultPendingTimeout = 10 * time.Second
+
+	//连接池选择连接的方式
+	PoolRoundRobin     = 0
+	PoolLeastPending   = 1
+	PoolConsistentHash = 2
+
+	poolDefaultQueue = 1024
+	poolHashReplicas = 64
+
+	//消息记录的类型
+	RecordOpen   = byte(1)
+	RecordClose  = byte(2)
+	RecordIn     = byte(3)
+	RecordOut    = byte(4)
+	RecordResume = byte(5) //断线恢复,数据是新连接的会话ID
+
+	recordMagic    = "GSR1"
+	recordHeadSize = 25
 )
 
+//NewSessionManager 没有监听,用AddListener添加
+func NewSessionManager(name string, maxsession uint32, hook func(gnet.ISocket, []byte) bool) *SessionManager {
+	return &SessionManager{SessionMsgProxy: SessionMsgProxy{msghanders: make(map[uint32]*msgProxy)}, maxsession: maxsession, pendtimeout: DefaultPendingTimeout, name: name, hook: hook}
+}
+
 func NewWsSessionManager(name string, host string, maxmsgsize uint32, maxsession uint32, hook func(gnet.ISocket, []byte) bool) *SessionManager {
-	return &SessionManager{server: gnet.NewWebSocketServer(host, maxmsgsize), SessionMsgProxy: SessionMsgProxy{msghanders: make(map[uint32]*msgProxy)}, maxsession: maxsession, name: name, hook: hook}
+	manager := NewSessionManager(name, maxsession, hook)
+	manager.AddListener("ws", gnet.NewWebSocketServer(host, maxmsgsize))
+	return manager
 }
 
+//NewWsSessionClient rcontime是第一次重连的秒数,之后翻倍,最多backoffMaxTimes倍
 func NewWsSessionClient(name string, curl string, rcontime int32, maxsession uint32) *SessionClient {
-	return &SessionClient{BaseSession: BaseSession{ws: gnet.NewWebSocketClient(curl, maxsession)}, SessionMsgProxy: SessionMsgProxy{msghanders: make(map[uint32]*msgProxy)}, rcontime: rcontime, name: name}
+	backoff := time.Duration(rcontime) * time.Second
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	return &SessionClient{BaseSession: BaseSession{ws: gnet.NewWebSocketClient(curl, maxsession)}, SessionMsgProxy: SessionMsgProxy{msghanders: make(map[uint32]*msgProxy)}, rcontime: rcontime, name: name,
+		backoffmin: backoff, backoffmax: backoff * backoffMaxTimes, noreconnect: map[uint32]bool{KickCodeBanned: true, KickCodeDuplicate: true}}
+}
+
+//NewWsSessionClientPool 每个地址conns个连接,policy是PoolRoundRobin这些
+func NewWsSessionClientPool(name string, urls []string, conns int, rcontime int32, maxmsgsize uint32, policy int) *SessionClientPool {
+	if conns <= 0 {
+		conns = 1
+	}
+	pool := &SessionClientPool{SessionMsgProxy: SessionMsgProxy{msghanders: make(map[uint32]*msgProxy)}, links: make(map[string][]*SessionClient),
+		conns: conns, rcontime: rcontime, maxmsgsize: maxmsgsize, policy: policy, maxqueue: poolDefaultQueue, name: name}
+	for _, curl := range urls {
+		pool.AddUrl(curl)
+	}
+	return pool
+}
+
+//NewSessionRecorder 记录写到w,先写文件头
+func NewSessionRecorder(w io.Writer) *SessionRecorder {
+	rec := &SessionRecorder{w: bufio.NewWriter(w)}
+	_, rec.err = rec.w.WriteString(recordMagic)
+	return rec
+}
+
+//NewFileSessionRecorder 记录写到文件,已经存在的会清空
+func NewFileSessionRecorder(filename string) (*SessionRecorder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	rec := NewSessionRecorder(file)
+	rec.closer = file
+	return rec, nil
+}
+
+//NewSessionRecordReader 检查文件头
+func NewSessionRecordReader(r io.Reader) (*SessionRecordReader, error) {
+	reader := &SessionRecordReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(reader.r, magic); err != nil || string(magic) != recordMagic {
+		return nil, ErrRecordHeader
+	}
+	return reader, nil
+}
+
+//NewSessionReplay 回放用的SessionManager,maxsession要比记录里同时在线的多
+func NewSessionReplay(name string, maxmsgsize uint32, maxsession uint32) *SessionReplay {
+	server := gnet.NewMemServer(maxmsgsize)
+	manager := NewSessionManager(name, maxsession, nil)
+	manager.AddListener("replay", server)
+	return &SessionReplay{manager: manager, server: server, sockets: make(map[uint64]*gnet.MemSocket)}
+}
+
+//NewSessionMsgProxy 独立的消息注册表
+func NewSessionMsgProxy() *SessionMsgProxy {
+	return &SessionMsgProxy{msghanders: make(map[uint32]*msgProxy)}
+}
+
+func isSysMsg(msgid uint32) bool {
+	return msgid >= SysMsgIdBase
+}
+
+//NewPresence 空的在线索引
+func NewPresence() *Presence {
+	return &Presence{keys: make(map[presenceKey]ISession), sessions: make(map[ISession]map[string]string)}
+}
+
+//NewMsgDebug 默认关闭,没有过滤
+func NewMsgDebug() *MsgDebug {
+	return &MsgDebug{}
 }
@@ -0,0 +1,193 @@
+package session
+
+import (
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+)
+
+//sysMsgSeq 开启恢复后所有下行消息都包一层序号
+type sysMsgSeq struct {
+	seq  uint64
+	data []byte
+}
+
+func (msg *sysMsgSeq) GetProId() uint32 {
+	return SysMsgIdSeq
+}
+
+func (msg *sysMsgSeq) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt64(msg.seq)
+	packer.PackBytes(msg.data)
+}
+
+func (msg *sysMsgSeq) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	if r, msg.seq = unpacker.UnPackUInt64(); r != 0 {
+		return unpacker.InField(r, "seq")
+	}
+	r, msg.data = unpacker.UnPackBytes()
+	return unpacker.InField(r, "data")
+}
+
+//sysMsgResumeToken 服务器下发恢复凭证,grace单位秒
+type sysMsgResumeToken struct {
+	token string
+	grace uint32
+}
+
+func (msg *sysMsgResumeToken) GetProId() uint32 {
+	return SysMsgIdResumeToken
+}
+
+func (msg *sysMsgResumeToken) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackString(msg.token)
+	packer.PackUInt32(msg.grace)
+}
+
+func (msg *sysMsgResumeToken) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	if r, msg.token = unpacker.UnPackString(); r != 0 {
+		return unpacker.InField(r, "token")
+	}
+	r, msg.grace = unpacker.UnPackUInt32()
+	return unpacker.InField(r, "grace")
+}
+
+//sysMsgResume 客户端连上后第一条消息,token为空表示新会话,seq为已经收到的最大序号
+type sysMsgResume struct {
+	token string
+	seq   uint64
+}
+
+func (msg *sysMsgResume) GetProId() uint32 {
+	return SysMsgIdResume
+}
+
+func (msg *sysMsgResume) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackString(msg.token)
+	packer.PackUInt64(msg.seq)
+}
+
+func (msg *sysMsgResume) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	if r, msg.token = unpacker.UnPackString(); r != 0 {
+		return unpacker.InField(r, "token")
+	}
+	r, msg.seq = unpacker.UnPackUInt64()
+	return unpacker.InField(r, "seq")
+}
+
+//sysMsgResumeResult 恢复结果
+type sysMsgResumeResult struct {
+	ok bool
+}
+
+func (msg *sysMsgResumeResult) GetProId() uint32 {
+	return SysMsgIdResumeResult
+}
+
+func (msg *sysMsgResumeResult) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackBool(msg.ok)
+}
+
+func (msg *sysMsgResumeResult) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	r, msg.ok = unpacker.UnPackBool()
+	return unpacker.InField(r, "ok")
+}
+
+//sysMsgSecureKey 用服务器公钥加密过的AES密钥,是加密会话的第一条消息
+type sysMsgSecureKey struct {
+	key []byte
+}
+
+func (msg *sysMsgSecureKey) GetProId() uint32 {
+	return SysMsgIdSecureKey
+}
+
+func (msg *sysMsgSecureKey) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackBytes(msg.key)
+}
+
+func (msg *sysMsgSecureKey) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	r, msg.key = unpacker.UnPackBytes()
+	return unpacker.InField(r, "key")
+}
+
+//sysMsgSecureNonce 服务器收到密钥后回的随机数,明文发送,之后双方的包都加密
+type sysMsgSecureNonce struct {
+	nonce []byte
+}
+
+func (msg *sysMsgSecureNonce) GetProId() uint32 {
+	return SysMsgIdSecureNonce
+}
+
+func (msg *sysMsgSecureNonce) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackBytes(msg.nonce)
+}
+
+func (msg *sysMsgSecureNonce) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	r, msg.nonce = unpacker.UnPackBytes()
+	return unpacker.InField(r, "nonce")
+}
+
+//sysMsgKick 服务器踢人的原因,发完就关闭连接
+type sysMsgKick struct {
+	code uint32
+	text string
+}
+
+func (msg *sysMsgKick) GetProId() uint32 {
+	return SysMsgIdKick
+}
+
+func (msg *sysMsgKick) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt32(msg.code)
+	packer.PackString(msg.text)
+}
+
+func (msg *sysMsgKick) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	if r, msg.code = unpacker.UnPackUInt32(); r != 0 {
+		return unpacker.InField(r, "code")
+	}
+	r, msg.text = unpacker.UnPackString()
+	return unpacker.InField(r, "text")
+}
+
+//packMsg 打包成独立的一份数据,可以放心保存
+func packMsg(msg protocolbase.IMsg, mode int) []byte {
+	packer := msgpack.PopPacker()
+	defer msgpack.PushPacker(packer)
+	packer.ClearBuffer()
+	packer.SetMode(mode)
+	msg.Pack(packer, true)
+	data := packer.GetBuffer()
+	out := make([]byte, len(data))
+	copy(out, data)
+	return out
+}
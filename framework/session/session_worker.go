@@ -0,0 +1,90 @@
+package session
+
+import (
+	"g_server/framework/com"
+	"g_server/framework/datastruct"
+	"g_server/framework/msgpack"
+)
+
+const (
+	workerJobSize = 1024
+)
+
+type workerJob struct {
+	session *Session
+	proxy   *msgProxy
+	id      uint32
+	data    []byte
+	err     error
+}
+
+//sessionWorker 按会话ID分片的工作协程,同一个会话总是落在同一个协程
+type sessionWorker struct {
+	jobs   []chan *workerJob
+	done   *datastruct.SyncQueue
+	limits *msgpack.DecodeLimits
+}
+
+func (worker *sessionWorker) start(num int, limits *msgpack.DecodeLimits) {
+	worker.limits = limits
+	worker.jobs = make([]chan *workerJob, num)
+	worker.done = datastruct.NewSyncQueue()
+	for i := range worker.jobs {
+		worker.jobs[i] = make(chan *workerJob, workerJobSize)
+		go worker.run(worker.jobs[i])
+	}
+}
+
+func (worker *sessionWorker) stop() {
+	for _, jobs := range worker.jobs {
+		close(jobs)
+	}
+	worker.jobs = nil
+}
+
+func (worker *sessionWorker) shard(session *Session) chan *workerJob {
+	return worker.jobs[session.ID()%uint64(len(worker.jobs))]
+}
+
+//busy 会话所在的分片排满了,只有主循环投递,不满的话post不会阻塞
+func (worker *sessionWorker) busy(session *Session) bool {
+	jobs := worker.shard(session)
+	return len(jobs) >= cap(jobs)
+}
+
+func (worker *sessionWorker) post(job *workerJob) {
+	worker.shard(job.session) <- job
+}
+
+func (worker *sessionWorker) run(jobs chan *workerJob) {
+	unpacker := msgpack.NewUnPacker()
+	if worker.limits != nil {
+		unpacker.SetLimits(*worker.limits)
+	}
+	for job := range jobs {
+		com.SafeCall(func() {
+			unpacker.SetMode(job.session.mode)
+			unpacker.Attatch(job.data)
+			unpacker.UnPackUInt32()
+			msg := job.proxy.msgCreate()
+			if msg == nil {
+				job.err = ErrMsgCreateNil
+				return
+			}
+			job.err = unpackMsg(msg, job.id, unpacker)
+			job.proxy.msgHandler(job.session, msg, job.err == nil)
+		})
+		worker.done.Push(job)
+	}
+}
+
+//popDone 主循环取执行完的任务
+func (worker *sessionWorker) popDone() *workerJob {
+	if worker.done == nil {
+		return nil
+	}
+	if job := worker.done.Pop(); job != nil {
+		return job.(*workerJob)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"g_server/framework/gnet"
+	"g_server/framework/msgpack"
+	"g_server/framework/protocolbase"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testMsgId = uint32(100)
+
+type testMsg struct {
+	value uint32
+}
+
+func (msg *testMsg) GetProId() uint32 {
+	return testMsgId
+}
+
+func (msg *testMsg) Pack(packer protocolbase.IPacker, clear bool) {
+	if clear {
+		packer.ClearBuffer()
+	}
+	packer.PackUInt32(msg.GetProId())
+	packer.PackUInt32(msg.value)
+}
+
+func (msg *testMsg) Unpack(unpacker protocolbase.IUnpacker) (r int) {
+	r, msg.value = unpacker.UnPackUInt32()
+	return unpacker.InField(r, "value")
+}
+
+func testManager(t *testing.T, setup func(*SessionManager)) (*SessionManager, *gnet.MemServer) {
+	t.Helper()
+	manager := NewSessionManager("test", 100, nil)
+	server := &gnet.MemServer{}
+	manager.AddListener("mem", server)
+	manager.EnableWorker(1)
+	setup(manager)
+	if !manager.Start() {
+		t.Fatal("start fail")
+	}
+	t.Cleanup(func() { manager.Stop() })
+	return manager, server
+}
+
+//runUntil 跑主循环直到条件满足
+func runUntil(t *testing.T, manager *SessionManager, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		manager.Run()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//TestWorkerCloseAfterInflight 工作协程还在处理时断线,关闭回调要等处理完
+func TestWorkerCloseAfterInflight(t *testing.T) {
+	release := make(chan struct{})
+	var handled, closed int32
+	manager, server := testManager(t, func(manager *SessionManager) {
+		manager.RegIMsgWorkerHandler(testMsgId, func(s ISession, msg protocolbase.IMsg, ok bool) {
+			<-release
+			atomic.StoreInt32(&handled, 1)
+		}, func() protocolbase.IMsg { return &testMsg{} })
+		manager.RegSessionClose(func(s ISession) {
+			if atomic.LoadInt32(&handled) == 0 {
+				t.Error("close callback before worker handler finished")
+			}
+			atomic.StoreInt32(&closed, 1)
+		})
+	})
+	ws := server.Dial("mem")
+	manager.Run()
+	ws.Recv(packMsg(&testMsg{value: 1}, msgpack.ModeLegacy))
+	manager.Run()
+	ws.Close()
+	for i := 0; i < 10; i++ {
+		manager.Run()
+	}
+	if atomic.LoadInt32(&closed) != 0 {
+		t.Fatal("closed while job in flight")
+	}
+	close(release)
+	runUntil(t, manager, func() bool { return atomic.LoadInt32(&closed) == 1 })
+}
+
+//TestWorkerBusyNotBlock 分片排满时主循环不阻塞,消息留在会话队列里之后再投递
+func TestWorkerBusyNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var count int32
+	manager, server := testManager(t, func(manager *SessionManager) {
+		manager.RegIMsgWorkerHandler(testMsgId, func(s ISession, msg protocolbase.IMsg, ok bool) {
+			<-release
+			atomic.AddInt32(&count, 1)
+		}, func() protocolbase.IMsg { return &testMsg{} })
+	})
+	ws := server.Dial("mem")
+	manager.Run()
+	total := workerJobSize + 100
+	for i := 0; i < total; i++ {
+		ws.Recv(packMsg(&testMsg{value: uint32(i)}, msgpack.ModeLegacy))
+	}
+	done := make(chan struct{})
+	go func() {
+		manager.Run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("main loop blocked on a full worker shard")
+	}
+	close(release)
+	runUntil(t, manager, func() bool { return atomic.LoadInt32(&count) == int32(total) })
+}
+
+//TestWorkerSendWhileKick 工作协程发消息的同时主循环踢人,用-race跑
+func TestWorkerSendWhileKick(t *testing.T) {
+	var sent int32
+	manager, server := testManager(t, func(manager *SessionManager) {
+		manager.EnableResume(time.Minute, 16)
+		manager.RegIMsgWorkerHandler(testMsgId, func(s ISession, msg protocolbase.IMsg, ok bool) {
+			for i := 0; i < 200; i++ {
+				s.SendMsg(msg)
+			}
+			atomic.AddInt32(&sent, 1)
+		}, func() protocolbase.IMsg { return &testMsg{} })
+	})
+	ws := server.Dial("mem")
+	manager.Run()
+	ws.Recv(packMsg(&testMsg{value: 1}, msgpack.ModeLegacy))
+	runUntil(t, manager, func() bool { return manager.Count() == 1 })
+	ws.Recv(packMsg(&testMsg{value: 2}, msgpack.ModeLegacy))
+	manager.Run()
+	manager.KickAll(1, "bye")
+	runUntil(t, manager, func() bool { return atomic.LoadInt32(&sent) == 2 })
+}
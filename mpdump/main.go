@@ -0,0 +1,134 @@
+//mpdump 把msgpack数据打印成json,方便看玩家反馈的包和回放文件
+//
+//	mpdump 93a161c3c0            命令行上的hex或者base64
+//	mpdump -f packet.bin         文件里的原始数据
+//	echo '{"a":1}' | mpdump -e   json转成msgpack的hex
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"g_server/framework/msgpack"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	file   = flag.String("f", "", "read raw msgpack from file, - for stdin")
+	format = flag.String("fmt", "auto", "input text format: auto, hex, base64")
+	one    = flag.Bool("one", false, "input is a single value, fail on trailing data")
+	encode = flag.Bool("e", false, "read json and print msgpack as hex")
+	spec   = flag.Bool("spec", false, "standard big-endian msgpack instead of the legacy little-endian format")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: mpdump [flags] [hex|base64]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "mpdump:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	data, err := input()
+	if err != nil {
+		return err
+	}
+	mode := msgpack.ModeLegacy
+	if *spec {
+		mode = msgpack.ModeSpec
+	}
+	if *encode {
+		out, err := msgpack.FromJSONMode(data, mode)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString(out))
+		return nil
+	}
+	unpacker := msgpack.NewUnPacker()
+	unpacker.SetMode(mode)
+	unpacker.Attatch(data)
+	//消息包是多个值连在一起,能解多少打印多少
+	values, err := unpacker.UnPackAll()
+	if err == nil && *one && len(values) != 1 {
+		return msgpack.ErrDecodeTrailing
+	}
+	for _, value := range values {
+		if perr := show(value); perr != nil {
+			return perr
+		}
+	}
+	return err
+}
+
+func show(value interface{}) error {
+	out, err := json.MarshalIndent(msgpack.JSONValue(value), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+//input 文件是原始数据,命令行和标准输入是文本
+func input() ([]byte, error) {
+	if *file == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	if *file != "" {
+		return os.ReadFile(*file)
+	}
+	var text string
+	if flag.NArg() > 0 {
+		text = strings.Join(flag.Args(), "")
+	} else {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	if *encode {
+		return []byte(text), nil
+	}
+	return decodeText(text)
+}
+
+func decodeText(text string) ([]byte, error) {
+	text = strings.Join(strings.Fields(text), "")
+	switch *format {
+	case "hex":
+		return hex.DecodeString(cleanHex(text))
+	case "base64":
+		return decodeBase64(text)
+	}
+	if data, err := hex.DecodeString(cleanHex(text)); err == nil {
+		return data, nil
+	}
+	return decodeBase64(text)
+}
+
+//cleanHex 去掉0x前缀和常见的分隔符
+func cleanHex(text string) string {
+	text = strings.ReplaceAll(text, "0x", "")
+	text = strings.ReplaceAll(text, ",", "")
+	return strings.ReplaceAll(text, ":", "")
+}
+
+func decodeBase64(text string) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := enc.DecodeString(text); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("input is neither hex nor base64")
+}
@@ -0,0 +1,108 @@
+//sessreplay 把SessionRecorder记的文件按顺序打印出来,看玩家报的问题时先用它找到出问题的会话
+//要重现问题得在业务代码里注册消息处理,再用session.SessionReplay回放
+//
+//	sessreplay record.bin             打印所有记录
+//	sessreplay -s 12 record.bin       只看会话12
+//	sessreplay -spec record.bin       会话用的是标准MessagePack
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"g_server/framework/msgpack"
+	"g_server/framework/session"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	sid  = flag.Uint64("s", 0, "only print records of this session id, 0 for all")
+	spec = flag.Bool("spec", false, "messages use standard big-endian msgpack instead of the legacy little-endian format")
+	raw  = flag.Bool("raw", false, "print message data as hex instead of json")
+)
+
+var typeNames = map[byte]string{
+	session.RecordOpen:   "open",
+	session.RecordClose:  "close",
+	session.RecordIn:     "in",
+	session.RecordOut:    "out",
+	session.RecordResume: "resume",
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: sessreplay [flags] file")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "sessreplay:", err)
+		os.Exit(1)
+	}
+}
+
+func run(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := session.NewSessionRecordReader(file)
+	if err != nil {
+		return err
+	}
+	mode := msgpack.ModeLegacy
+	if *spec {
+		mode = msgpack.ModeSpec
+	}
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if *sid != 0 && rec.SessionId != *sid {
+			continue
+		}
+		line := fmt.Sprintf("%s #%d %s %s", rec.Time.Format("2006-01-02 15:04:05.000"), rec.SessionId, typeName(rec.Type), detail(rec, mode))
+		fmt.Println(strings.TrimSpace(line))
+	}
+}
+
+func typeName(typ byte) string {
+	if name, ok := typeNames[typ]; ok {
+		return name
+	}
+	return fmt.Sprint("type", typ)
+}
+
+//detail 消息打印消息号和去掉消息号后的字段
+func detail(rec *session.SessionRecord, mode int) string {
+	switch rec.Type {
+	case session.RecordOpen:
+		return string(rec.Data)
+	case session.RecordResume:
+		if len(rec.Data) == 8 {
+			return fmt.Sprint("from #", binary.BigEndian.Uint64(rec.Data))
+		}
+	case session.RecordIn, session.RecordOut:
+		if !*raw {
+			if values, err := msgpack.DecodeAllMode(rec.Data, mode); err == nil && len(values) > 0 {
+				if out, err := json.Marshal(msgpack.JSONValue(values[1:])); err == nil {
+					return fmt.Sprint(rec.MsgId, " ", string(out))
+				}
+			}
+		}
+		return fmt.Sprintf("%d %x", rec.MsgId, rec.Data)
+	}
+	return fmt.Sprintf("%x", rec.Data)
+}